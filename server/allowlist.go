@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HeaderForwardedFor is set by the reverse proxies in front of Mattermost
+const HeaderForwardedFor = "X-Forwarded-For"
+
+// splitAddressList splits a setting holding a list of addresses separated by commas, spaces or new lines
+func splitAddressList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+// ipNetFromIP returns a network holding only the given IP
+func ipNetFromIP(ip net.IP) *net.IPNet {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// resolveAddressList parses a list of IPs, CIDRs and hostnames, resolving the hostnames to their current IPs.
+// The entries that can't be parsed or resolved are returned separately, so they can be reported.
+func resolveAddressList(addresses []string) (networks []*net.IPNet, invalid []string) {
+	for _, address := range addresses {
+		if strings.Contains(address, "/") {
+			_, network, err := net.ParseCIDR(address)
+			if err != nil {
+				invalid = append(invalid, address)
+				continue
+			}
+			networks = append(networks, network)
+			continue
+		}
+
+		if ip := net.ParseIP(address); ip != nil {
+			networks = append(networks, ipNetFromIP(ip))
+			continue
+		}
+
+		ips, err := net.LookupIP(address)
+		if err != nil || len(ips) == 0 {
+			invalid = append(invalid, address)
+			continue
+		}
+		for _, ip := range ips {
+			networks = append(networks, ipNetFromIP(ip))
+		}
+	}
+
+	return networks, invalid
+}
+
+// containsIP checks if the IP belongs to one of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveWopiAllowList resolves the WOPI allow-list and the trusted proxies of the configuration.
+// When no allow-list is set, only the addresses the WOPI address resolves to are allowed.
+func (p *Plugin) resolveWopiAllowList(c *configuration) {
+	allowList := splitAddressList(c.WOPIAllowList)
+	if len(allowList) == 0 {
+		if wopiURL, err := url.Parse(c.WOPIAddress); err == nil && wopiURL.Hostname() != "" {
+			allowList = []string{wopiURL.Hostname()}
+		}
+	}
+
+	var invalid []string
+	c.wopiAllowList, invalid = resolveAddressList(allowList)
+	if len(invalid) > 0 {
+		p.API.LogWarn("Some addresses of the WOPI allow-list can't be parsed or resolved and are ignored.", "Addresses", strings.Join(invalid, ", "))
+	}
+	if len(c.wopiAllowList) == 0 {
+		p.API.LogError("The WOPI allow-list is empty. All the requests from Collabora Online will be rejected.")
+	}
+
+	c.trustedProxies, invalid = resolveAddressList(splitAddressList(c.TrustedProxies))
+	if len(invalid) > 0 {
+		p.API.LogWarn("Some trusted proxies can't be parsed or resolved and are ignored.", "Addresses", strings.Join(invalid, ", "))
+	}
+}
+
+// getClientIP returns the IP of the client that sent the request.
+// The X-Forwarded-For header is only followed through the trusted proxies, from the closest one,
+// so that a client can't spoof its address by sending the header itself.
+func getClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	var forwardedFor []string
+	for _, header := range r.Header.Values(HeaderForwardedFor) {
+		forwardedFor = append(forwardedFor, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+		if ip == nil || !containsIP(trustedProxies, ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// withWopiAllowList rejects the WOPI requests that don't come from an allowed Collabora Online server
+func (p *Plugin) withWopiAllowList(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := p.getConfiguration()
+		ip := getClientIP(r, config.trustedProxies)
+		if ip == nil || !containsIP(config.wopiAllowList, ip) {
+			p.API.LogWarn("Rejected a WOPI request from an address not in the allow-list.", "RequestURI", r.URL.Path, "RemoteAddr", r.RemoteAddr, "ClientIP", ip.String())
+			http.Error(w, "Forbidden.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestGetClientIP(t *testing.T) {
+	trustedProxies, invalid := resolveAddressList([]string{"10.0.0.1", "192.168.0.0/16"})
+	if len(invalid) > 0 {
+		t.Fatalf("failed to parse the trusted proxies: %v", invalid)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"direct request", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"direct request without port", "203.0.113.5", nil, "203.0.113.5"},
+		{"header from an untrusted client is ignored", "203.0.113.5:1234", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"through a trusted proxy", "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"through several trusted proxies", "10.0.0.1:1234", []string{"198.51.100.7, 192.168.1.2"}, "198.51.100.7"},
+		{"through several headers", "10.0.0.1:1234", []string{"198.51.100.7", "192.168.1.2"}, "198.51.100.7"},
+		{"spoofed address before the client is ignored", "10.0.0.1:1234", []string{"127.0.0.1, 198.51.100.7"}, "198.51.100.7"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"192.168.1.2"}, "192.168.1.2"},
+		{"trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"IPv6 client", "10.0.0.1:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: test.remoteAddr, Header: http.Header{}}
+			for _, value := range test.forwardedFor {
+				r.Header.Add(HeaderForwardedFor, value)
+			}
+
+			ip := getClientIP(r, trustedProxies)
+			if !ip.Equal(net.ParseIP(test.expected)) {
+				t.Errorf("expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+
+	t.Run("invalid address in the header", func(t *testing.T) {
+		r := &http.Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{}}
+		r.Header.Set(HeaderForwardedFor, "not an ip")
+		if ip := getClientIP(r, trustedProxies); ip != nil {
+			t.Errorf("expected no IP, got %s", ip)
+		}
+	})
+}
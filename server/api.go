@@ -3,21 +3,66 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
 
 	root "github.com/CollaboraOnline/collabora-mattermost"
 )
 
 const (
 	HeaderMattermostUserID = "Mattermost-User-Id"
+
+	HeaderWopiOverride = "X-WOPI-Override"
+	HeaderWopiLock     = "X-WOPI-Lock"
+	HeaderWopiOldLock  = "X-WOPI-OldLock"
+
+	HeaderWopiLockFailureReason = "X-WOPI-LockFailureReason"
+
+	HeaderWopiSuggestedTarget = "X-WOPI-SuggestedTarget"
+	HeaderWopiRelativeTarget  = "X-WOPI-RelativeTarget"
+
+	HeaderWopiRequestedName        = "X-WOPI-RequestedName"
+	HeaderWopiInvalidFileNameError = "X-WOPI-InvalidFileNameError"
+
+	HeaderWopiItemVersion    = "X-WOPI-ItemVersion"
+	HeaderCoolWopiIsAutosave = "X-COOL-WOPI-IsAutosave"
+	HeaderCoolWopiIsExitSave = "X-COOL-WOPI-IsExitSave"
+	HeaderCoolWopiIsModified = "X-COOL-WOPI-IsModifiedByUser"
+	HeaderCoolWopiTimestamp  = "X-COOL-WOPI-Timestamp"
+	HeaderLoolWopiTimestamp  = "X-LOOL-WOPI-Timestamp"
+
+	// HeaderWopiPingNonce echoes the nonce of the WOPI ping, even if the request is rejected by the WOPI middlewares
+	HeaderWopiPingNonce = "X-Mattermost-WOPI-Ping-Nonce"
+
+	WopiOverrideLock        = "LOCK"
+	WopiOverrideUnlock      = "UNLOCK"
+	WopiOverrideRefreshLock = "REFRESH_LOCK"
+	WopiOverrideGetLock     = "GET_LOCK"
+	WopiOverridePutRelative = "PUT_RELATIVE"
+	WopiOverrideRenameFile  = "RENAME_FILE"
+)
+
+const (
+	// fileNameMaxLength is the maximum length of a file name, including the extension
+	fileNameMaxLength = 255
+
+	// invalidFileNameChars are the characters not allowed in file names
+	invalidFileNameChars = `\/:*?"<>|`
+
+	// wopiStatusDocumentChanged is the Collabora Online status code sent when the file was modified externally
+	wopiStatusDocumentChanged = 1010
 )
 
 // InitAPI initializes the REST API
@@ -30,14 +75,41 @@ func (p *Plugin) InitAPI() *mux.Router {
 
 	// Add the custom plugin routes here
 	s.HandleFunc("/channels/{channelID:[A-Za-z0-9_-]+}/files/new", handleAuthRequired(p.createFileFromTemplate)).Methods(http.MethodPost).Queries("name", "{name}", "ext", "{ext}")
+	s.HandleFunc("/channels/{channelID:[A-Za-z0-9_-]+}/editingPolicy", handleAuthRequired(p.getEditingPolicy)).Methods(http.MethodGet)
+	s.HandleFunc("/channels/{channelID:[A-Za-z0-9_-]+}/editingPolicy", handleAuthRequired(p.setEditingPolicy)).Methods(http.MethodPut)
 	s.HandleFunc("/fileInfo", handleAuthRequired(p.parseFileIDs)).Methods(http.MethodGet)
 	s.HandleFunc("/wopiFileList", handleAuthRequired(p.returnWopiFileList)).Methods(http.MethodGet)
+	s.HandleFunc("/capabilities", handleAuthRequired(p.returnCapabilities)).Methods(http.MethodGet)
 	s.HandleFunc("/collaboraURL", handleAuthRequired(p.returnCollaboraOnlineFileURL)).Methods(http.MethodGet)
-	s.HandleFunc("/wopi/files/{fileID:[a-z0-9]+}", p.getWopiFileInfo).Methods(http.MethodGet)
-	s.HandleFunc("/wopi/files/{fileID:[a-z0-9]+}/contents", p.getWopiFileContents).Methods(http.MethodGet)
-	s.HandleFunc("/wopi/files/{fileID:[a-z0-9]+}/edit", p.getWopiFileInfoEditable).Methods(http.MethodGet)
-	s.HandleFunc("/wopi/files/{fileID:[a-z0-9]+}/edit/contents", p.getWopiFileContents).Methods(http.MethodGet)
-	s.HandleFunc("/wopi/files/{fileID:[a-z0-9]+}/edit/contents", p.saveWopiFileContents).Methods(http.MethodPost)
+	s.HandleFunc("/accessToken", handleAuthRequired(p.refreshAccessToken)).Methods(http.MethodPost)
+	s.HandleFunc("/admin/revokeTokens", p.handleAdminRequired(p.revokeTokens)).Methods(http.MethodPost)
+	s.HandleFunc("/admin/refreshDiscovery", p.handleAdminRequired(p.refreshDiscoveryNow)).Methods(http.MethodPost)
+	s.HandleFunc("/admin/status", p.handleAdminRequired(p.returnDiscoveryStatus)).Methods(http.MethodGet)
+	s.HandleFunc("/admin/diagnostics", p.handleAdminRequired(p.runDiagnosticsNow)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/editRestriction", handleAuthRequired(p.getEditRestriction)).Methods(http.MethodGet)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/editRestriction", handleAuthRequired(p.setEditRestriction)).Methods(http.MethodPut)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/approval", handleAuthRequired(p.getApproval)).Methods(http.MethodGet)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/approval", handleAuthRequired(p.requestApproval)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/approval/decision", handleAuthRequired(p.decideApproval)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/approval/reopen", handleAuthRequired(p.reopenApproval)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/checkout", handleAuthRequired(p.getCheckout)).Methods(http.MethodGet)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/checkout", handleAuthRequired(p.checkOut)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/checkin", handleAuthRequired(p.checkIn)).Methods(http.MethodPost)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/versions", handleAuthRequired(p.getFileVersionList)).Methods(http.MethodGet)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/versions/{versionID:[a-z0-9]+}", handleAuthRequired(p.downloadFileVersion)).Methods(http.MethodGet)
+	s.HandleFunc("/files/{fileID:[a-z0-9]+}/versions/{versionID:[a-z0-9]+}/restore", handleAuthRequired(p.restoreFileVersion)).Methods(http.MethodPost)
+
+	// WOPI routes, called by Collabora Online
+	wopi := s.PathPrefix("/wopi").Subrouter()
+	wopi.Use(withWopiPingNonce, p.withWopiAllowList, p.withWopiProofValidation)
+	wopi.HandleFunc("/ping", p.returnWopiPing).Methods(http.MethodGet).Queries("nonce", "{nonce:[a-z0-9]+}")
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}", p.getWopiFileInfo).Methods(http.MethodGet)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}", p.handleWopiFileOperation).Methods(http.MethodPost)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}/contents", p.getWopiFileContents).Methods(http.MethodGet)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}/edit", p.getWopiFileInfoEditable).Methods(http.MethodGet)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}/edit", p.handleWopiFileOperation).Methods(http.MethodPost)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}/edit/contents", p.getWopiFileContents).Methods(http.MethodGet)
+	wopi.HandleFunc("/files/{fileID:[a-z0-9]+}/edit/contents", p.saveWopiFileContents).Methods(http.MethodPost)
 
 	// 404 handler
 	r.Handle("{anything:.*}", http.NotFoundHandler())
@@ -96,16 +168,24 @@ func handleAuthRequired(handleFunc func(w http.ResponseWriter, r *http.Request))
 	}
 }
 
+// handleAdminRequired verifies if provided request is performed by a Mattermost system admin.
+func (p *Plugin) handleAdminRequired(handleFunc func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return handleAuthRequired(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get(HeaderMattermostUserID)
+		if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handleFunc(w, r)
+	})
+}
+
 // createFileFromTemplate creates a new file from template in the given channel
 func (p *Plugin) createFileFromTemplate(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	channelID := params["channelID"]
-	_, channelErr := p.API.GetChannel(channelID)
-	if channelErr != nil {
-		p.API.LogError("Invalid or missing channel ID: ", channelErr.Error(), "channelID", channelID)
-		http.Error(w, channelErr.Error(), http.StatusBadRequest)
-		return
-	}
+	userID := r.Header.Get(HeaderMattermostUserID)
 
 	fileName := r.URL.Query().Get("name")
 	if fileName == "" {
@@ -126,6 +206,25 @@ func (p *Plugin) createFileFromTemplate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := validateFileName(fileName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// creating a file requires editing it: the user must be allowed to post and upload files in the channel,
+	// and the other rules of the policy, such as the read-only mode of archived channels, apply
+	access, err := p.getNewFileAccess(userID, channelID, fileName+"."+fileExt)
+	if err != nil {
+		p.API.LogError("Invalid or missing channel ID: ", err.Error(), "channelID", channelID)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !access.CanEdit {
+		p.API.LogError("User: " + userID + " is not allowed to create a file in the channel: " + channelID)
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
 	bundlePath, err := p.API.GetBundlePath()
 	if err != nil {
 		p.API.LogWarn("Failed to get bundle path.", "Error", err.Error())
@@ -152,7 +251,7 @@ func (p *Plugin) createFileFromTemplate(w http.ResponseWriter, r *http.Request)
 
 	post := &model.Post{
 		ChannelId: channelID,
-		UserId:    r.Header.Get(HeaderMattermostUserID),
+		UserId:    userID,
 		FileIds:   model.StringArray{fileInfo.Id},
 	}
 
@@ -165,6 +264,68 @@ func (p *Plugin) createFileFromTemplate(w http.ResponseWriter, r *http.Request)
 	returnStatusOK(w)
 }
 
+// getEditingPolicy returns the editing policy of a channel, and whether it overrides the default policy
+func (p *Plugin) getEditingPolicy(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["channelID"]
+	userID := r.Header.Get(HeaderMattermostUserID)
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_READ_CHANNEL) {
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	override, err := p.getChannelEditingPolicyOverride(channelID)
+	if err != nil {
+		p.API.LogError("Failed to get the channel editing policy.", "ChannelID", channelID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Policy     string `json:"policy"`
+		IsOverride bool   `json:"is_override"`
+	}{override, override != ""}
+	if override == "" {
+		response.Policy = p.getConfiguration().EditingPolicy
+	}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// setEditingPolicy overrides the editing policy of a channel, allowed to the channel admins.
+// body contains a JSON object with the policy, an empty policy restores the default policy.
+func (p *Plugin) setEditingPolicy(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["channelID"]
+	userID := r.Header.Get(HeaderMattermostUserID)
+	if !p.isChannelAdmin(userID, channelID) {
+		p.API.LogError("User: " + userID + " is not allowed to change the editing policy of the channel: " + channelID)
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Policy string `json:"policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Policy != "" && !isValidEditingPolicy(request.Policy) {
+		http.Error(w, "invalid editing policy", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.setChannelEditingPolicy(channelID, request.Policy); err != nil {
+		p.API.LogError("Failed to set the channel editing policy.", "ChannelID", channelID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	returnStatusOK(w)
+}
+
 // parseFileIDs sends the file info to the client (name, extension and id) for each file
 // body contains an array with file ids in JSON format
 func (p *Plugin) parseFileIDs(w http.ResponseWriter, r *http.Request) {
@@ -183,23 +344,35 @@ func (p *Plugin) parseFileIDs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create an array with more detailed file info for each file
+	// create an array with more detailed file info for each file the user has access to
+	userID := r.Header.Get(HeaderMattermostUserID)
 	files := make([]ClientFileInfo, 0, len(fileIDs))
 	for _, fileID := range fileIDs {
-		fileInfo, fileInfoError := p.API.GetFileInfo(fileID)
-		if fileInfoError != nil {
-			p.API.LogError("Error when retrieving file info: ", fileInfoError.Error())
+		access, accessError := p.getFileAccessByID(userID, fileID)
+		if accessError != nil {
+			p.API.LogError("Error when retrieving file access: ", accessError.Error())
 			continue
 		}
-		if value, ok := WopiFiles[strings.ToLower(fileInfo.Extension)]; ok {
-			file := ClientFileInfo{
-				fileInfo.Id,
-				fileInfo.Name,
-				fileInfo.Extension,
-				value.Action,
-			}
-			files = append(files, file)
+
+		if !access.CanView {
+			continue
+		}
+
+		fileInfo := access.FileInfo
+		app, ok := p.discovery.Get().getApp(fileInfo)
+		if !ok {
+			continue
 		}
+
+		action, _ := app.getAction(access.Scope())
+		file := ClientFileInfo{
+			fileInfo.Id,
+			fileInfo.Name,
+			fileInfo.Extension,
+			action,
+			access.Permissions(),
+		}
+		files = append(files, file)
 	}
 
 	responseJSON, _ := json.Marshal(files)
@@ -207,9 +380,43 @@ func (p *Plugin) parseFileIDs(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(responseJSON)
 }
 
-// returnWopiFileList returns the list with file extensions and actions associated with these files
+// returnWopiFileList returns the apps handling each file extension and MIME type, along with their actions.
+// The MIME types are listed with the extensions, they can't be mistaken for one another as MIME types contain a slash.
+// While Collabora Online is unreachable, available is false and the files of the last known discovery are listed
+// without their URLs, so that the webapp keeps showing them in the preview and explains why they can't be opened.
 func (p *Plugin) returnWopiFileList(w http.ResponseWriter, _ *http.Request) {
-	responseJSON, _ := json.Marshal(WopiFiles)
+	discovery := p.discovery.Get()
+	files, mimeTypes := discovery.Files, discovery.MimeTypes
+	if !discovery.isLoaded() {
+		files, mimeTypes = p.getLastKnownApps()
+	}
+
+	apps := make(map[string]WopiApp, len(files)+len(mimeTypes))
+	for mimeType, app := range mimeTypes {
+		apps[mimeType] = app
+	}
+	for ext, app := range files {
+		apps[ext] = app
+	}
+
+	response := struct {
+		Available bool               `json:"available"`
+		Message   string             `json:"message,omitempty"`
+		Files     map[string]WopiApp `json:"files"`
+	}{Available: discovery.isLoaded(), Files: apps}
+	if !response.Available {
+		response.Message = "Editing is temporarily unavailable, Collabora Online can't be reached."
+	}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// returnCapabilities returns the features supported by the Collabora Online server, or null if the server didn't provide them
+func (p *Plugin) returnCapabilities(w http.ResponseWriter, _ *http.Request) {
+	responseJSON, _ := json.Marshal(p.discovery.Get().Capabilities)
+	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(responseJSON)
 }
 
@@ -224,58 +431,85 @@ func (p *Plugin) returnCollaboraOnlineFileURL(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	file, fileError := p.API.GetFileInfo(fileID)
-	if fileError != nil {
-		p.API.LogError("Failed to retrieve file. Error: ", fileError.Error())
-		http.Error(w, "Invalid fileID. Error: "+fileError.Error(), http.StatusBadRequest)
+	userID := r.Header.Get(HeaderMattermostUserID)
+	access, ok := p.getFileForUser(w, fileID, userID)
+	if !ok {
+		return
+	}
+
+	discovery := p.discovery.Get()
+	if !discovery.isLoaded() {
+		http.Error(w, "Editing is temporarily unavailable, Collabora Online can't be reached.", http.StatusServiceUnavailable)
+		return
+	}
+
+	app, ok := discovery.getApp(access.FileInfo)
+	if !ok {
+		http.Error(w, "Collabora Online doesn't support this file type.", http.StatusBadRequest)
+		return
+	}
+
+	scope := access.Scope()
+	action, actionURL := app.getAction(scope)
+	if actionURL == "" {
+		http.Error(w, "Collabora Online can't open this file type.", http.StatusBadRequest)
 		return
 	}
 
-	wopiURL := WopiFiles[strings.ToLower(file.Extension)].URL + "WOPISrc=" + (p.getBaseAPIURL() + "/wopi/files/" + fileID)
-	wopiToken := p.EncodeToken(r.Header.Get(HeaderMattermostUserID), fileID)
+	wopiURL := actionURL + "WOPISrc=" + (p.getBaseAPIURL() + "/wopi/files/" + fileID)
+	wopiToken, wopiTokenTTL := p.EncodeToken(userID, fileID, scope)
 
 	response := struct {
-		URL         string `json:"url"`
-		AccessToken string `json:"access_token"` // client will pass this token as a POST parameter to Collabora Online when loading the iframe
-	}{wopiURL, wopiToken}
+		URL            string `json:"url"`
+		AccessToken    string `json:"access_token"`     // client will pass this token as a POST parameter to Collabora Online when loading the iframe
+		AccessTokenTTL int64  `json:"access_token_ttl"` // expiry time of the token in milliseconds since the epoch, passed to Collabora Online with the token
+		Scope          string `json:"scope"`            // view, comment or edit
+		Action         string `json:"action"`           // the discovery action of the URL: edit, view or view_comment
+		Notice         string `json:"notice,omitempty"` // explains why the file is read-only
+		FilePermissions
+	}{wopiURL, wopiToken, wopiTokenTTL, scope, action, access.Notice, access.Permissions()}
 
 	responseJSON, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(responseJSON)
 }
 
-// getWopiFileContents is used by Collabora Online server to get the contents of a file
-func (p *Plugin) getWopiFileContents(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	fileID := params["fileID"]
-
-	wopiToken, tokenErr := p.GetWopiTokenFromURI(r.RequestURI)
-	if tokenErr != nil || wopiToken.FileID != fileID {
-		p.API.LogError(fmt.Sprintf("Invalid token. Error: %v", tokenErr))
-		http.Error(w, "Invalid token.", http.StatusBadRequest)
+// refreshAccessToken returns a new token for a file, used by the client to renew the token
+// of a Collabora Online editing session before it expires
+func (p *Plugin) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	fileID := r.URL.Query().Get("file_id")
+	if fileID == "" {
+		http.Error(w, "missing file_id parameter", http.StatusBadRequest)
 		return
 	}
 
-	fileInfo, fileInfoError := p.API.GetFileInfo(fileID)
-	if fileInfoError != nil {
-		p.API.LogError("Error occurred when retrieving file info: " + fileInfoError.Error())
-		http.Error(w, fileInfoError.Error(), http.StatusInternalServerError)
+	userID := r.Header.Get(HeaderMattermostUserID)
+	access, ok := p.getFileForUser(w, fileID, userID)
+	if !ok {
 		return
 	}
 
-	post, postError := p.API.GetPost(fileInfo.PostId)
-	if postError != nil {
-		p.API.LogError("Error occurred when retrieving post info for file: " + postError.Error())
-		http.Error(w, postError.Error(), http.StatusInternalServerError)
-		return
-	}
+	scope := access.Scope()
+	wopiToken, wopiTokenTTL := p.EncodeToken(userID, fileID, scope)
 
-	// check if user has access to the channel where the file was sent
-	if !p.API.HasPermissionToChannel(wopiToken.UserID, post.ChannelId, model.PERMISSION_READ_CHANNEL) {
-		p.API.LogError("User: " + wopiToken.UserID + " does not have the appropriate permissions: PERMISSION_READ_CHANNEL. Channel: " + post.ChannelId)
-		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+	response := struct {
+		AccessToken    string `json:"access_token"`
+		AccessTokenTTL int64  `json:"access_token_ttl"`
+		Scope          string `json:"scope"`
+	}{wopiToken, wopiTokenTTL, scope}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// getWopiFileContents is used by Collabora Online server to get the contents of a file
+func (p *Plugin) getWopiFileContents(w http.ResponseWriter, r *http.Request) {
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
 		return
 	}
+	fileID := access.FileInfo.Id
 
 	fileContent, getFileErr := p.API.GetFile(fileID)
 	if getFileErr != nil {
@@ -285,105 +519,128 @@ func (p *Plugin) getWopiFileContents(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// send file to Collabora Online
+	w.Header().Set(HeaderWopiItemVersion, p.getFileVersion(fileID))
 	_, _ = w.Write(fileContent)
 }
 
 // saveWopiFileContents is used by Collabora Online server to save the updated contents of a file
 func (p *Plugin) saveWopiFileContents(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	fileID := params["fileID"]
+	wopiToken, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, post := access.FileInfo, access.Post
+	fileID := fileInfo.Id
 
-	wopiToken, tokenErr := p.GetWopiTokenFromURI(r.RequestURI)
-	if tokenErr != nil || wopiToken.FileID != fileID {
-		p.API.LogError(fmt.Sprintf("Invalid token. Error: %v", tokenErr))
-		http.Error(w, "Invalid token.", http.StatusBadRequest)
+	if p.writeCheckoutConflict(w, access) {
+		p.API.LogWarn("Rejected saving a checked out file.", "FileID", fileID, "UserID", wopiToken.UserID)
+		return
+	}
+
+	// view and comment tokens can't be used to save the file, whichever URL Collabora Online was given
+	if !access.CanWrite() {
+		p.API.LogError("User: " + wopiToken.UserID + " tried to save the file: " + fileID + " with a token of scope: " + wopiToken.Scope)
+		http.Error(w, access.getDeniedMessage(), http.StatusForbidden)
 		return
 	}
 
-	fileInfo, fileInfoError := p.API.GetFileInfo(fileID)
-	if fileInfoError != nil {
-		p.API.LogError("Error occurred when retrieving file info: " + fileInfoError.Error())
-		http.Error(w, fileInfoError.Error(), http.StatusInternalServerError)
+	// reject the save if the file is locked by another session
+	if currentLockID, err := p.lockManager.CanWrite(fileID, r.Header.Get(HeaderWopiLock)); err != nil {
+		if errors.Is(err, errLockMismatch) {
+			p.API.LogWarn("Rejected saving a locked file.", "FileID", fileID, "UserID", wopiToken.UserID)
+			w.Header().Set(HeaderWopiLock, currentLockID)
+			http.Error(w, "The file is locked by another session.", http.StatusConflict)
+			return
+		}
+
+		p.API.LogError("Failed to check the file lock.", "FileID", fileID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	post, postError := p.API.GetPost(fileInfo.PostId)
-	if postError != nil {
-		p.API.LogError("Error occurred when retrieving post info for file: " + postError.Error())
-		http.Error(w, postError.Error(), http.StatusInternalServerError)
+	// reject the save if the file was modified since Collabora Online loaded it,
+	// so the user can choose to overwrite the changes or to reload the file
+	if timestamp := getWopiTimestampFromRequest(r); timestamp != "" && timestamp != formatWopiTimestamp(fileInfo.UpdateAt) {
+		p.API.LogWarn("Rejected saving a file modified since it was loaded.", "FileID", fileID, "UserID", wopiToken.UserID, "Timestamp", timestamp)
+		responseJSON, _ := json.Marshal(WopiConflictResponse{wopiStatusDocumentChanged, wopiStatusDocumentChanged})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write(responseJSON)
 		return
 	}
 
-	// check if user has access to the channel where the file was sent
-	if !p.API.HasPermissionToChannel(wopiToken.UserID, post.ChannelId, model.PERMISSION_READ_CHANNEL) {
-		p.API.LogError("User: " + wopiToken.UserID + " does not have the appropriate permissions: PERMISSION_READ_CHANNEL. Channel: " + post.ChannelId)
-		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+	data, ok := p.readFileContentsFromRequest(w, r)
+	if !ok {
 		return
 	}
 
 	// save file received from Collabora Online
-	if _, err := p.WriteFile(r.Body, fileInfo.Path); err != nil {
+	save := &FileSave{
+		FileInfo: fileInfo,
+		Post:     post,
+		UserID:   wopiToken.UserID,
+		Data:     data,
+
+		// older Collabora Online versions don't send IsModifiedByUser, only saving files modified by the user
+		IsModifiedByUser: r.Header.Get(HeaderCoolWopiIsModified) != "false",
+		IsAutosave:       r.Header.Get(HeaderCoolWopiIsAutosave) == "true",
+		IsExitSave:       r.Header.Get(HeaderCoolWopiIsExitSave) == "true",
+	}
+	if err := p.saveFileContents(save); err != nil {
 		p.API.LogError("Failed to save the updated file contents.", "Error", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	returnStatusOK(w)
+	responseJSON, _ := json.Marshal(WopiPutFileResponse{formatWopiTimestamp(save.FileInfo.UpdateAt)})
+	w.Header().Set(HeaderWopiItemVersion, strconv.FormatInt(save.Metadata.Version, 10))
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
 }
 
 // generateWopiFileInfo generates the file information, used by Collabora Online
 // see: http:// wopi.readthedocs.io/projects/wopirest/en/latest/files/CheckFileInfo.html#checkfileinfo
-func (p *Plugin) generateWopiFileInfo(wopiToken WopiToken, userCanEdit bool) (*WopiCheckFileInfo, error) {
-	user, userErr := p.API.GetUser(wopiToken.UserID)
-	if userErr != nil {
-		p.API.LogError("Error retrieving user. Token UserID is corrupted or the user doesn't exist.", "TokenUserID", wopiToken.UserID, "Error", userErr.Error())
-		return nil, userErr
-	}
-
-	fileInfo, fileInfoErr := p.API.GetFileInfo(wopiToken.FileID)
-	if fileInfoErr != nil {
-		p.API.LogError("Error retrieving file info", "FileID", wopiToken.FileID, "Error", fileInfoErr.Error())
-		return nil, fileInfoErr
-	}
-
-	post, postErr := p.API.GetPost(fileInfo.PostId)
-	if postErr != nil {
-		p.API.LogError("Error retrieving file's post.", "PostID", fileInfo.PostId, "Error", postErr.Error())
-		return nil, postErr
+func (p *Plugin) generateWopiFileInfo(access *FileAccess, userCanEdit bool) *WopiCheckFileInfo {
+	fileInfo, user := access.FileInfo, access.User
+
+	// only advertise the operations the Collabora Online server can handle
+	capabilities := p.discovery.Get().Capabilities
+
+	// templates are opened as a new document in the channel, which needs the same permissions as "Save As"
+	templateSaveAs := ""
+	if capabilities.SupportsTemplateSaveAs() && userCanEdit && access.CanExport {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileInfo.Name)), ".")
+		if documentExt, ok := DocumentFromTemplateExt[ext]; ok {
+			templateSaveAs = strings.TrimSuffix(fileInfo.Name, filepath.Ext(fileInfo.Name)) + "." + documentExt
+		}
 	}
 
-	wopiFileInfo := &WopiCheckFileInfo{
+	return &WopiCheckFileInfo{
 		BaseFileName:            fileInfo.Name,
 		Size:                    fileInfo.Size,
-		OwnerID:                 post.UserId,
+		OwnerID:                 access.Post.UserId,
 		UserID:                  user.Id,
 		UserFriendlyName:        user.GetDisplayName(model.SHOW_FULLNAME),
-		UserCanWrite:            userCanEdit,
-		UserCanNotWriteRelative: true,
+		UserCanWrite:            userCanEdit && access.CanWrite(),
+		UserCanNotWriteRelative: !(userCanEdit && access.CanExport), // "Save As" creates a new post in the channel
+		SupportsLocks:           true,
+		SupportsGetLock:         true,
+		SupportsRename:          true,
+		UserCanRename:           userCanEdit && access.CanRename,
+		TemplateSaveAs:          templateSaveAs,
+		Version:                 p.getFileVersion(fileInfo.Id),
+		LastModifiedTime:        formatWopiTimestamp(fileInfo.UpdateAt),
 	}
-
-	return wopiFileInfo, nil
 }
 
 // getWopiFileInfo returns the file information, used by Collabora Online
 func (p *Plugin) getWopiFileInfo(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	fileID := params["fileID"]
-
-	wopiToken, tokenErr := p.GetWopiTokenFromURI(r.RequestURI)
-	if tokenErr != nil || wopiToken.FileID != fileID {
-		p.API.LogError(fmt.Sprintf("Invalid token. Error: %v", tokenErr))
-		http.Error(w, "Invalid token.", http.StatusBadRequest)
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
 		return
 	}
 
-	wopiFileInfo, wopiFileInfoErr := p.generateWopiFileInfo(wopiToken, false)
-	if wopiFileInfoErr != nil {
-		http.Error(w, wopiFileInfoErr.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	responseJSON, _ := json.Marshal(wopiFileInfo)
+	responseJSON, _ := json.Marshal(p.generateWopiFileInfo(access, false))
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(responseJSON)
 }
@@ -391,6 +648,20 @@ func (p *Plugin) getWopiFileInfo(w http.ResponseWriter, r *http.Request) {
 // getWopiFileInfoEditable returns the file information, used by Collabora Online
 // with editable set to true
 func (p *Plugin) getWopiFileInfoEditable(w http.ResponseWriter, r *http.Request) {
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+
+	responseJSON, _ := json.Marshal(p.generateWopiFileInfo(access, true))
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// validateWopiRequest validates the token of a request sent by Collabora Online and decides
+// what the token user can do with the requested file, limited to the scope of the token.
+// If the request can't be served the error response is written and false is returned.
+func (p *Plugin) validateWopiRequest(w http.ResponseWriter, r *http.Request) (WopiToken, *FileAccess, bool) {
 	params := mux.Vars(r)
 	fileID := params["fileID"]
 
@@ -398,16 +669,1034 @@ func (p *Plugin) getWopiFileInfoEditable(w http.ResponseWriter, r *http.Request)
 	if tokenErr != nil || wopiToken.FileID != fileID {
 		p.API.LogError(fmt.Sprintf("Invalid token. Error: %v", tokenErr))
 		http.Error(w, "Invalid token.", http.StatusBadRequest)
+		return WopiToken{}, nil, false
+	}
+
+	access, err := p.getFileAccessByID(wopiToken.UserID, fileID)
+	if err != nil {
+		p.API.LogError("Error occurred when retrieving the file access: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return WopiToken{}, nil, false
+	}
+
+	// check if user has access to the channel where the file was sent
+	if !access.CanView {
+		p.API.LogError("User: " + wopiToken.UserID + " is not allowed to view the file: " + fileID)
+		http.Error(w, access.getDeniedMessage(), http.StatusForbidden)
+		return WopiToken{}, nil, false
+	}
+
+	if err := p.checkTokenRevocation(wopiToken, access.User, access.Channel); err != nil {
+		if errors.Is(err, errTokenRevoked) {
+			p.API.LogWarn("Rejected a revoked token.", "UserID", wopiToken.UserID, "FileID", fileID, "TokenID", wopiToken.Id, "Reason", err.Error())
+			http.Error(w, "Invalid token.", http.StatusUnauthorized)
+			return WopiToken{}, nil, false
+		}
+
+		p.API.LogError("Failed to check the token revocation.", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return WopiToken{}, nil, false
+	}
+
+	access.LimitToScope(wopiToken.Scope)
+	return wopiToken, access, true
+}
+
+// handleWopiFileOperation dispatches the WOPI file operations sent by Collabora Online
+// as a POST request with the X-WOPI-Override header
+func (p *Plugin) handleWopiFileOperation(w http.ResponseWriter, r *http.Request) {
+	switch r.Header.Get(HeaderWopiOverride) {
+	case WopiOverrideLock:
+		p.lockWopiFile(w, r)
+	case WopiOverrideUnlock:
+		p.unlockWopiFile(w, r)
+	case WopiOverrideRefreshLock:
+		p.refreshWopiFileLock(w, r)
+	case WopiOverrideGetLock:
+		p.getWopiFileLock(w, r)
+	case WopiOverridePutRelative:
+		p.putRelativeWopiFile(w, r)
+	case WopiOverrideRenameFile:
+		p.renameWopiFile(w, r)
+	default:
+		p.API.LogWarn("Unsupported WOPI operation.", "Operation", r.Header.Get(HeaderWopiOverride))
+		http.Error(w, "Unsupported WOPI operation.", http.StatusNotImplemented)
+	}
+}
+
+// writeWopiLockResponse writes the response of a lock operation.
+// On lock mismatch, the current lock ID is returned with the 409 Conflict status.
+func (p *Plugin) writeWopiLockResponse(w http.ResponseWriter, fileID, currentLockID string, err error) {
+	if err != nil {
+		if errors.Is(err, errLockMismatch) {
+			w.Header().Set(HeaderWopiLock, currentLockID)
+			http.Error(w, "Lock mismatch.", http.StatusConflict)
+			return
+		}
+
+		p.API.LogError("Failed to update the file lock.", "FileID", fileID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	returnStatusOK(w)
+}
+
+// writeCheckoutConflict answers the requests of the sessions of the users who didn't check out the file
+// as if the file was locked by another session, and returns true if the file is checked out by another user
+func (p *Plugin) writeCheckoutConflict(w http.ResponseWriter, access *FileAccess) bool {
+	if access.Checkout == nil || access.Checkout.UserID == access.User.Id {
+		return false
+	}
+
+	w.Header().Set(HeaderWopiLock, access.Checkout.lockID())
+	w.Header().Set(HeaderWopiLockFailureReason, access.getDeniedMessage())
+	http.Error(w, "The file is checked out by another user.", http.StatusConflict)
+	return true
+}
+
+// getLockIDFromRequest returns the lock ID sent by Collabora Online
+// If the lock ID is missing or invalid, an error response is written and false is returned.
+func getLockIDFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	lockID := r.Header.Get(HeaderWopiLock)
+	if lockID == "" || len(lockID) > wopiLockIDMaxLength {
+		http.Error(w, "Missing or invalid lock ID.", http.StatusBadRequest)
+		return "", false
+	}
+	return lockID, true
+}
+
+// lockWopiFile handles the WOPI Lock and UnlockAndRelock operations
+func (p *Plugin) lockWopiFile(w http.ResponseWriter, r *http.Request) {
+	wopiToken, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	if p.writeCheckoutConflict(w, access) {
+		return
+	}
+
+	if !access.CanWrite() {
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	lockID, ok := getLockIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	currentLockID, err := p.lockManager.Lock(fileInfo.Id, wopiToken.UserID, lockID, r.Header.Get(HeaderWopiOldLock))
+	p.writeWopiLockResponse(w, fileInfo.Id, currentLockID, err)
+}
+
+// unlockWopiFile handles the WOPI Unlock operation
+func (p *Plugin) unlockWopiFile(w http.ResponseWriter, r *http.Request) {
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	lockID, ok := getLockIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	currentLockID, err := p.lockManager.Unlock(fileInfo.Id, lockID)
+	p.writeWopiLockResponse(w, fileInfo.Id, currentLockID, err)
+}
+
+// refreshWopiFileLock handles the WOPI RefreshLock operation
+func (p *Plugin) refreshWopiFileLock(w http.ResponseWriter, r *http.Request) {
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	if p.writeCheckoutConflict(w, access) {
+		return
+	}
+
+	if !access.CanWrite() {
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	lockID, ok := getLockIDFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	wopiFileInfo, wopiFileInfoErr := p.generateWopiFileInfo(wopiToken, true)
-	if wopiFileInfoErr != nil {
-		http.Error(w, wopiFileInfoErr.Error(), http.StatusInternalServerError)
+	currentLockID, err := p.lockManager.RefreshLock(fileInfo.Id, lockID)
+	p.writeWopiLockResponse(w, fileInfo.Id, currentLockID, err)
+}
+
+// getWopiFileLock handles the WOPI GetLock operation
+func (p *Plugin) getWopiFileLock(w http.ResponseWriter, r *http.Request) {
+	_, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	currentLockID, err := p.lockManager.GetLock(fileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the file lock.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	responseJSON, _ := json.Marshal(wopiFileInfo)
+	// a file checked out by another user is reported as locked to the other sessions
+	if currentLockID == "" && access.Checkout != nil && access.Checkout.UserID != access.User.Id {
+		currentLockID = access.Checkout.lockID()
+	}
+
+	w.Header().Set(HeaderWopiLock, currentLockID)
+	returnStatusOK(w)
+}
+
+// getWopiTimestampFromRequest returns the modification time of the file when Collabora Online loaded it
+func getWopiTimestampFromRequest(r *http.Request) string {
+	if timestamp := r.Header.Get(HeaderCoolWopiTimestamp); timestamp != "" {
+		return timestamp
+	}
+	return r.Header.Get(HeaderLoolWopiTimestamp)
+}
+
+// readFileContentsFromRequest reads the file contents sent in the request body, enforcing the Mattermost maximum file size.
+// If the contents can't be read the error response is written and false is returned.
+func (p *Plugin) readFileContentsFromRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	maxFileSize := *p.API.GetConfig().FileSettings.MaxFileSize
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxFileSize+1))
+	if err != nil {
+		p.API.LogError("Failed to read the file contents.", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if int64(len(data)) > maxFileSize {
+		http.Error(w, "The file is too large.", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	return data, true
+}
+
+// getRelativeTargetName returns the name of the file to be created by PutRelativeFile.
+// X-WOPI-SuggestedTarget is either a full file name or only an extension starting with a dot,
+// while X-WOPI-RelativeTarget must be used as is. Only one of them can be present.
+func getRelativeTargetName(r *http.Request, sourceFileName string) (string, error) {
+	suggestedTarget := r.Header.Get(HeaderWopiSuggestedTarget)
+	relativeTarget := r.Header.Get(HeaderWopiRelativeTarget)
+	if (suggestedTarget == "") == (relativeTarget == "") {
+		return "", errors.New("exactly one of X-WOPI-SuggestedTarget and X-WOPI-RelativeTarget must be provided")
+	}
+
+	target := relativeTarget
+	if suggestedTarget != "" {
+		target = suggestedTarget
+	}
+
+	name, err := decodeUTF7(target)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode the target file name")
+	}
+
+	// an extension is a single dot followed by the extension, such as ".pdf"
+	if suggestedTarget != "" && strings.HasPrefix(name, ".") {
+		if len(name) == 1 || filepath.Ext(name) != name {
+			return "", errors.New("invalid target file extension")
+		}
+		name = strings.TrimSuffix(sourceFileName, filepath.Ext(sourceFileName)) + name
+	}
+
+	// the target is a file name, not a path: it is rejected rather than reduced to its last element
+	name = strings.TrimSpace(name)
+	if err := validateFileName(name); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(name, ".") {
+		return "", errors.New("the file name can't start with a dot")
+	}
+
+	return name, nil
+}
+
+// putRelativeWopiFile handles the WOPI PutRelativeFile operation, used by the "Save As" and "Export as" actions.
+// The new file is uploaded to the channel of the source file and posted in the same place as the source file.
+func (p *Plugin) putRelativeWopiFile(w http.ResponseWriter, r *http.Request) {
+	wopiToken, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, post := access.FileInfo, access.Post
+
+	if !access.CanExport {
+		p.API.LogError("User: " + wopiToken.UserID + " is not allowed to save a copy of the file: " + fileInfo.Id)
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	fileName, err := getRelativeTargetName(r, fileInfo.Name)
+	if err != nil {
+		p.API.LogWarn("Invalid PutRelativeFile target.", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, ok := p.readFileContentsFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	newFileInfo, appErr := p.API.UploadFile(data, post.ChannelId, fileName)
+	if appErr != nil {
+		p.API.LogError("Failed to upload the new file.", "Error", appErr.Error())
+		http.Error(w, appErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// reply in the thread if the source file was posted in a thread
+	newPost := &model.Post{
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		UserId:    wopiToken.UserID,
+		FileIds:   model.StringArray{newFileInfo.Id},
+	}
+
+	newPost, appErr = p.API.CreatePost(newPost)
+	if appErr != nil {
+		p.API.LogError("Failed to create post with the new file.", "Error", appErr.Error())
+		http.Error(w, appErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newAccess, err := p.getFileAccess(wopiToken.UserID, newFileInfo, newPost)
+	if err != nil {
+		p.API.LogError("Failed to get the access to the new file.", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newWopiToken, _ := p.EncodeToken(wopiToken.UserID, newFileInfo.Id, newAccess.Scope())
+	response := WopiPutRelativeFileResponse{
+		Name: newFileInfo.Name,
+		URL:  p.getBaseAPIURL() + "/wopi/files/" + newFileInfo.Id + "?access_token=" + url.QueryEscape(newWopiToken),
+	}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// validateFileName checks that the name can be used as a file name
+func validateFileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("the file name can't be empty")
+	}
+
+	if len(name) > fileNameMaxLength {
+		return errors.Errorf("the file name can't be longer than %d characters", fileNameMaxLength)
+	}
+
+	if strings.ContainsAny(name, invalidFileNameChars) {
+		return errors.Errorf("the file name can't contain any of the following characters: %s", invalidFileNameChars)
+	}
+
+	for _, c := range name {
+		if c < ' ' {
+			return errors.New("the file name can't contain control characters")
+		}
+	}
+
+	if name == "." || name == ".." {
+		return errors.New("invalid file name")
+	}
+
+	return nil
+}
+
+// renameWopiFile handles the WOPI RenameFile operation.
+// The requested name doesn't contain the extension, which is kept unchanged.
+// The plugin API can't update the Mattermost FileInfo, so downloads and search keep the original name:
+// the new name is used by Collabora Online and shown in the post of the file.
+func (p *Plugin) renameWopiFile(w http.ResponseWriter, r *http.Request) {
+	wopiToken, access, ok := p.validateWopiRequest(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	if !access.CanRename {
+		p.API.LogError("User: " + wopiToken.UserID + " is not allowed to rename the file: " + fileInfo.Id)
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	if currentLockID, err := p.lockManager.CanWrite(fileInfo.Id, r.Header.Get(HeaderWopiLock)); err != nil {
+		p.writeWopiLockResponse(w, fileInfo.Id, currentLockID, err)
+		return
+	}
+
+	requestedName, err := decodeUTF7(r.Header.Get(HeaderWopiRequestedName))
+	if err == nil {
+		err = validateFileName(requestedName)
+	}
+	if err != nil {
+		w.Header().Set(HeaderWopiInvalidFileNameError, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newName := requestedName
+	if fileInfo.Extension != "" {
+		newName += "." + fileInfo.Extension
+	}
+
+	if _, err := p.updateFileMetadata(fileInfo.Id, func(metadata *FileMetadata) { metadata.Name = newName }); err != nil {
+		p.API.LogError("Failed to rename the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := p.updatePostFileName(access.Post, fileInfo.Id, newName); err != nil {
+		p.API.LogWarn("Failed to show the new name of the file in its post.", "FileID", fileInfo.Id, "PostID", access.Post.Id, "Error", err.Error())
+	}
+
+	response := WopiRenameFileResponse{Name: requestedName}
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// getAuthorizedFile returns the file requested by a Mattermost user, checking that the user has access to it.
+// If the request can't be served the error response is written and false is returned.
+func (p *Plugin) getAuthorizedFile(w http.ResponseWriter, r *http.Request) (*FileAccess, bool) {
+	return p.getFileForUser(w, mux.Vars(r)["fileID"], r.Header.Get(HeaderMattermostUserID))
+}
+
+// getFileForUser decides what the user can do with a file, checking that the user can view it.
+// If the user doesn't have access to the file the error response is written and false is returned.
+func (p *Plugin) getFileForUser(w http.ResponseWriter, fileID, userID string) (*FileAccess, bool) {
+	access, err := p.getFileAccessByID(userID, fileID)
+	if err != nil {
+		p.API.LogError("Error occurred when retrieving the file access: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !access.CanView {
+		p.API.LogError("User: " + userID + " is not allowed to view the file: " + fileID)
+		http.Error(w, access.getDeniedMessage(), http.StatusForbidden)
+		return nil, false
+	}
+
+	return access, true
+}
+
+// getEditRestriction returns the edit restriction of a file, and whether the user can change it
+func (p *Plugin) getEditRestriction(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+
+	restriction, err := p.getFileEditRestriction(access.FileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the file edit restriction.", "FileID", access.FileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Mode      string   `json:"mode"`
+		Usernames []string `json:"usernames"`
+		CanManage bool     `json:"can_manage"`
+	}{Usernames: []string{}, CanManage: p.canManageEditRestriction(access.User.Id, access.Post)}
+	if restriction != nil {
+		response.Mode = restriction.Mode
+		for _, userID := range restriction.UserIDs {
+			if user, appErr := p.API.GetUser(userID); appErr == nil {
+				response.Usernames = append(response.Usernames, user.Username)
+			}
+		}
+	}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// setEditRestriction restricts the editing of a file, allowed to the user who posted it and the channel admins.
+// body contains a JSON object with the mode (read_only, users or empty to remove the restriction)
+// and the usernames of the users allowed to edit the file.
+func (p *Plugin) setEditRestriction(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, post, userID := access.FileInfo, access.Post, access.User.Id
+
+	if !p.canManageEditRestriction(userID, post) {
+		p.API.LogError("User: " + userID + " is not allowed to restrict the editing of the file: " + fileInfo.Id)
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Mode      string   `json:"mode"`
+		Usernames []string `json:"usernames"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var restriction *FileEditRestriction
+	switch request.Mode {
+	case "":
+	case EditRestrictionReadOnly, EditRestrictionUsers:
+		restriction = &FileEditRestriction{
+			Mode:  request.Mode,
+			SetBy: userID,
+			SetAt: model.GetMillis(),
+		}
+	default:
+		http.Error(w, "invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	if request.Mode == EditRestrictionUsers {
+		for _, username := range request.Usernames {
+			user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+			if appErr != nil {
+				http.Error(w, "unknown user: "+username, http.StatusBadRequest)
+				return
+			}
+			restriction.UserIDs = append(restriction.UserIDs, user.Id)
+		}
+
+		if len(restriction.UserIDs) == 0 {
+			http.Error(w, "at least one user is required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := p.setFileEditRestriction(fileInfo.Id, restriction); err != nil {
+		p.API.LogError("Failed to set the file edit restriction.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := p.updatePostEditRestrictions(post, fileInfo, restriction); err != nil {
+		p.API.LogWarn("Failed to show the file edit restriction in its post.", "FileID", fileInfo.Id, "PostID", post.Id, "Error", err.Error())
+	}
+
+	returnStatusOK(w)
+}
+
+// getApproval returns the last review requested for a file, or null if none was requested
+func (p *Plugin) getApproval(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+
+	approval, err := p.getFileApproval(access.FileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the file approval.", "FileID", access.FileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(approval)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// requestApproval asks some users to review a file, allowed to the users who can edit it.
+// body contains a JSON object with the usernames of the reviewers.
+func (p *Plugin) requestApproval(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, post, userID := access.FileInfo, access.Post, access.User.Id
+
+	if !access.CanEdit {
+		p.API.LogError("User: " + userID + " is not allowed to request the review of the file: " + fileInfo.Id)
+		http.Error(w, access.getDeniedMessage(), http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Reviewers []string `json:"reviewers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reviewers []*model.User
+	for _, username := range request.Reviewers {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+		if appErr != nil {
+			http.Error(w, "unknown user: "+username, http.StatusBadRequest)
+			return
+		}
+
+		if !p.API.HasPermissionToChannel(user.Id, post.ChannelId, model.PERMISSION_READ_CHANNEL) {
+			http.Error(w, "@"+user.Username+" is not a member of the channel", http.StatusBadRequest)
+			return
+		}
+		reviewers = append(reviewers, user)
+	}
+
+	if len(reviewers) == 0 {
+		http.Error(w, "at least one reviewer is required", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := p.requestFileApproval(fileInfo, post, userID, reviewers)
+	if err != nil {
+		p.API.LogWarn("Failed to request the review of the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(approval)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// decideApproval handles the Approve and Request changes buttons of a review post
+func (p *Plugin) decideApproval(w http.ResponseWriter, r *http.Request) {
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeResponse := func(response *model.PostActionIntegrationResponse) {
+		responseJSON, _ := json.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(responseJSON)
+	}
+
+	approvalID, _ := request.Context["approval_id"].(string)
+	decision, _ := request.Context["decision"].(string)
+	if decision != ApprovalDecisionApprove && decision != ApprovalDecisionRequestChanges {
+		http.Error(w, "invalid decision", http.StatusBadRequest)
+		return
+	}
+
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, userID := access.FileInfo, access.User.Id
+
+	approval, err := p.decideFileApproval(fileInfo.Id, approvalID, userID, decision)
+	if err != nil {
+		writeResponse(&model.PostActionIntegrationResponse{EphemeralText: "Your decision wasn't recorded: " + err.Error() + "."})
+		return
+	}
+
+	if approval.Status != ApprovalStatusPending {
+		if err := p.postApprovalOutcome(fileInfo, access.Post, approval, userID); err != nil {
+			p.API.LogWarn("Failed to post the review outcome.", "FileID", fileInfo.Id, "Error", err.Error())
+		}
+	}
+
+	reviewPost, appErr := p.API.GetPost(approval.PostID)
+	if appErr != nil {
+		p.API.LogError("Failed to get the review post.", "PostID", approval.PostID, "Error", appErr.Error())
+		writeResponse(&model.PostActionIntegrationResponse{})
+		return
+	}
+
+	p.setApprovalAttachment(reviewPost, fileInfo, approval)
+	writeResponse(&model.PostActionIntegrationResponse{Update: reviewPost})
+}
+
+// reopenApproval handles the Reopen for changes button of an approved review post,
+// allowed to the reviewers who approved the file and to the channel admins.
+func (p *Plugin) reopenApproval(w http.ResponseWriter, r *http.Request) {
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeResponse := func(response *model.PostActionIntegrationResponse) {
+		responseJSON, _ := json.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(responseJSON)
+	}
+
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, userID := access.FileInfo, access.User.Id
+
+	current, err := p.getFileApproval(fileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the file approval.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current == nil {
+		http.Error(w, "no approval was requested for the file", http.StatusNotFound)
+		return
+	}
+
+	if !p.canReopenFileApproval(current, userID, access.Channel.Id) {
+		p.API.LogError("User: " + userID + " is not allowed to reopen the approved file: " + fileInfo.Id)
+		http.Error(w, "only the reviewers who approved the file or a channel admin can reopen it", http.StatusForbidden)
+		return
+	}
+
+	approvalID, _ := request.Context["approval_id"].(string)
+	approval, err := p.reopenFileApproval(fileInfo.Id, approvalID, userID)
+	if err != nil {
+		writeResponse(&model.PostActionIntegrationResponse{EphemeralText: "The file wasn't reopened: " + err.Error() + "."})
+		return
+	}
+
+	if err := p.postApprovalOutcome(fileInfo, access.Post, approval, userID); err != nil {
+		p.API.LogWarn("Failed to post the review outcome.", "FileID", fileInfo.Id, "Error", err.Error())
+	}
+
+	reviewPost, appErr := p.API.GetPost(approval.PostID)
+	if appErr != nil {
+		p.API.LogError("Failed to get the review post.", "PostID", approval.PostID, "Error", appErr.Error())
+		writeResponse(&model.PostActionIntegrationResponse{})
+		return
+	}
+
+	p.setApprovalAttachment(reviewPost, fileInfo, approval)
+	writeResponse(&model.PostActionIntegrationResponse{Update: reviewPost})
+}
+
+// getCheckout returns the check-out state of a file, and what the user can do with it
+func (p *Plugin) getCheckout(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	userID, checkout := access.User.Id, access.Checkout
+
+	response := struct {
+		CheckedOut  bool   `json:"checked_out"`
+		Username    string `json:"username"`
+		ExpiresAt   int64  `json:"expires_at"`
+		CanCheckOut bool   `json:"can_check_out"`
+		CanCheckIn  bool   `json:"can_check_in"`
+	}{CanCheckOut: access.CanEdit}
+	if checkout != nil {
+		response.CheckedOut = true
+		response.ExpiresAt = checkout.ExpiresAt
+		if user, appErr := p.API.GetUser(checkout.UserID); appErr == nil {
+			response.Username = user.Username
+		}
+		response.CanCheckIn = checkout.UserID == userID || p.isChannelAdmin(userID, access.Channel.Id)
+	}
+
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// checkOut gives the user the exclusive right to edit a file, allowed to the users who can edit it
+func (p *Plugin) checkOut(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, userID := access.FileInfo, access.User.Id
+
+	if !access.CanEdit {
+		p.API.LogError("User: " + userID + " is not allowed to check out the file: " + fileInfo.Id)
+		http.Error(w, access.getDeniedMessage(), http.StatusForbidden)
+		return
+	}
+
+	checkout, err := p.checkOutFile(fileInfo, access.Post, userID)
+	if err != nil {
+		if errors.Is(err, errFileCheckedOut) || errors.Is(err, errFileLockedByAnotherUser) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		p.API.LogError("Failed to check out the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(checkout)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// checkIn ends the check-out of a file, allowed to the user who checked it out and to the channel admins.
+// body contains a JSON object with an optional comment, recorded in the thread of the file.
+func (p *Plugin) checkIn(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo, userID := access.FileInfo, access.User.Id
+
+	var request struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := p.checkInFile(fileInfo, access.Post, userID, strings.TrimSpace(request.Comment)); err != nil {
+		if errors.Is(err, errFileCheckedOut) {
+			http.Error(w, "You can't check in a file checked out by another user.", http.StatusForbidden)
+			return
+		}
+
+		p.API.LogWarn("Failed to check in the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	returnStatusOK(w)
+}
+
+// getFileVersionList returns the previous versions of a file, the most recent first
+func (p *Plugin) getFileVersionList(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	versions, err := p.getFileVersions(fileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the file versions.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(versions)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// downloadFileVersion returns the contents of a previous version of a file
+func (p *Plugin) downloadFileVersion(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	versionID := mux.Vars(r)["versionID"]
+	version, err := p.getFileVersionByID(fileInfo.Id, versionID)
+	if err != nil {
+		p.API.LogError("Failed to get the file version.", "FileID", fileInfo.Id, "VersionID", versionID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if version == nil {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
+
+	contents, err := p.readFileVersion(fileInfo.Id, version.ID)
+	if err != nil {
+		p.API.LogError("Failed to read the file version.", "FileID", fileInfo.Id, "VersionID", version.ID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", fileInfo.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileInfo.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(contents)
+}
+
+// restoreFileVersion replaces the contents of a file with a previous version.
+// The current contents are kept as a new version, so the restore can be undone.
+func (p *Plugin) restoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	access, ok := p.getAuthorizedFile(w, r)
+	if !ok {
+		return
+	}
+	fileInfo := access.FileInfo
+
+	if !access.CanEdit {
+		http.Error(w, "You do not have the appropriate permissions.", http.StatusForbidden)
+		return
+	}
+
+	// restoring while the file is edited would be overwritten by the next save
+	currentLockID, err := p.lockManager.GetLock(fileInfo.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the lock of the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if currentLockID != "" {
+		http.Error(w, "The file is currently being edited.", http.StatusConflict)
+		return
+	}
+
+	versionID := mux.Vars(r)["versionID"]
+	version, err := p.getFileVersionByID(fileInfo.Id, versionID)
+	if err != nil {
+		p.API.LogError("Failed to get the file version.", "FileID", fileInfo.Id, "VersionID", versionID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if version == nil {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
+
+	contents, err := p.readFileVersion(fileInfo.Id, version.ID)
+	if err != nil {
+		p.API.LogError("Failed to read the file version.", "FileID", fileInfo.Id, "VersionID", version.ID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	save := &FileSave{
+		FileInfo: fileInfo,
+		Post:     access.Post,
+		UserID:   access.User.Id,
+		Data:     contents,
+
+		// a restore is an explicit change made by the user
+		IsModifiedByUser: true,
+	}
+	if err := p.saveFileContents(save); err != nil {
+		p.API.LogError("Failed to restore the file version.", "FileID", fileInfo.Id, "VersionID", version.ID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	returnStatusOK(w)
+}
+
+// revokeTokens revokes the WOPI tokens issued to a user, for a file, or a single token.
+// body contains a JSON object with the user_id, file_id or token to revoke.
+func (p *Plugin) revokeTokens(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		UserID string `json:"user_id"`
+		FileID string `json:"file_id"`
+		Token  string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.UserID == "" && request.FileID == "" && request.Token == "" {
+		http.Error(w, "one of user_id, file_id or token is required", http.StatusBadRequest)
+		return
+	}
+
+	if request.UserID != "" {
+		if err := p.RevokeUserTokens(request.UserID); err != nil {
+			p.API.LogError("Failed to revoke the user tokens.", "UserID", request.UserID, "Error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if request.FileID != "" {
+		if err := p.RevokeFileTokens(request.FileID); err != nil {
+			p.API.LogError("Failed to revoke the file tokens.", "FileID", request.FileID, "Error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if request.Token != "" {
+		wopiToken, isValid := p.DecodeToken(request.Token)
+		if !isValid {
+			http.Error(w, "invalid token", http.StatusBadRequest)
+			return
+		}
+
+		if err := p.RevokeToken(wopiToken); err != nil {
+			p.API.LogError("Failed to revoke the token.", "TokenID", wopiToken.Id, "Error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	p.API.LogInfo("WOPI tokens revoked.", "RevokedBy", r.Header.Get(HeaderMattermostUserID), "UserID", request.UserID, "FileID", request.FileID)
+	returnStatusOK(w)
+}
+
+// refreshDiscoveryNow fetches the discovery from the Collabora Online server without waiting for the periodic refresh,
+// for example after Collabora Online was upgraded. The other servers of the cluster load it within a minute.
+func (p *Plugin) refreshDiscoveryNow(w http.ResponseWriter, r *http.Request) {
+	wopiAddress := p.getConfiguration().WOPIAddress
+	discovery, err := p.refreshDiscovery(wopiAddress)
+	if err != nil {
+		p.API.LogError("Failed to refresh the WOPI discovery.", "Error", err.Error())
+		p.continueDiscoveryRetry(wopiAddress)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	p.stopDiscoveryRetry()
+
+	response := struct {
+		Extensions int   `json:"extensions"`
+		FetchedAt  int64 `json:"fetched_at"`
+	}{len(discovery.Files), discovery.FetchedAt}
+	responseJSON, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// returnDiscoveryStatus tells the system admins whether Collabora Online can be reached, and when the plugin retries to reach it
+func (p *Plugin) returnDiscoveryStatus(w http.ResponseWriter, _ *http.Request) {
+	responseJSON, _ := json.Marshal(p.getDiscoveryStatus())
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// runDiagnosticsNow checks the connection with Collabora Online and returns the report, for the "Test connection" button of the system console
+func (p *Plugin) runDiagnosticsNow(w http.ResponseWriter, _ *http.Request) {
+	report := p.runDiagnostics()
+	p.API.LogInfo("Ran the Collabora Online connection diagnostics.", "Status", report.Status)
+
+	responseJSON, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseJSON)
+}
+
+// withWopiPingNonce echoes the nonce of the WOPI ping in a header, so the diagnostics can tell that the request
+// reached the WOPI endpoints of this plugin even when it is rejected by the allow-list or the proof validation
+func withWopiPingNonce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if nonce := mux.Vars(r)["nonce"]; nonce != "" {
+			w.Header().Set(HeaderWopiPingNonce, nonce)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// returnWopiPing echoes the nonce, so the diagnostics can check that the WOPI URL leads to this plugin
+func (p *Plugin) returnWopiPing(w http.ResponseWriter, r *http.Request) {
+	responseJSON, _ := json.Marshal(map[string]string{"nonce": mux.Vars(r)["nonce"]})
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(responseJSON)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetRelativeTargetName(t *testing.T) {
+	tests := []struct {
+		name            string
+		suggestedTarget string
+		relativeTarget  string
+		expected        string
+		valid           bool
+	}{
+		{name: "suggested file name", suggestedTarget: "copy.odt", expected: "copy.odt", valid: true},
+		{name: "suggested extension", suggestedTarget: ".pdf", expected: "report.pdf", valid: true},
+		{name: "relative target", relativeTarget: "copy.docx", expected: "copy.docx", valid: true},
+		{name: "UTF-7 encoded name", relativeTarget: "r+AOk-sum+AOk-.odt", expected: "résumé.odt", valid: true},
+		{name: "surrounding spaces", suggestedTarget: " copy.odt ", expected: "copy.odt", valid: true},
+		{name: "no target"},
+		{name: "both targets", suggestedTarget: "a.odt", relativeTarget: "b.odt"},
+		{name: "dot", suggestedTarget: "."},
+		{name: "dot dot", suggestedTarget: ".."},
+		{name: "extension with several dots", suggestedTarget: "..pdf"},
+		{name: "path in the relative target", relativeTarget: "a/b.odt"},
+		{name: "parent directory in the relative target", relativeTarget: "../b.odt"},
+		{name: "Windows path in the suggested target", suggestedTarget: `a\b.odt`},
+		{name: "hidden file", relativeTarget: ".hidden"},
+		{name: "control character", relativeTarget: "a\x01.odt"},
+		{name: "invalid UTF-7", relativeTarget: "+A-.odt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &http.Request{Header: http.Header{}}
+			if test.suggestedTarget != "" {
+				r.Header.Set(HeaderWopiSuggestedTarget, test.suggestedTarget)
+			}
+			if test.relativeTarget != "" {
+				r.Header.Set(HeaderWopiRelativeTarget, test.relativeTarget)
+			}
+
+			name, err := getRelativeTargetName(r, "report.odt")
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %q", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
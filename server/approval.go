@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	root "github.com/CollaboraOnline/collabora-mattermost"
+)
+
+const (
+	// ApprovalStatusPending is the status of a review waiting for the decisions of the reviewers
+	ApprovalStatusPending = "pending"
+	// ApprovalStatusApproved is the status of a document approved by all the reviewers
+	ApprovalStatusApproved = "approved"
+	// ApprovalStatusChangesRequested is the status of a document a reviewer requested changes on
+	ApprovalStatusChangesRequested = "changes_requested"
+	// ApprovalStatusReopened is the status of an approved document reopened for changes
+	ApprovalStatusReopened = "reopened"
+
+	// ApprovalDecisionApprove and ApprovalDecisionRequestChanges are the decisions of a reviewer
+	ApprovalDecisionApprove        = "approve"
+	ApprovalDecisionRequestChanges = "request_changes"
+
+	// approvalKeyPrefix is the KV store key prefix used for the approval of a file
+	approvalKeyPrefix = "file_approval_"
+)
+
+var (
+	// errApprovalClosed is returned when deciding on a review that is no longer pending
+	errApprovalClosed = errors.New("the review is closed")
+	// errApprovalNotApproved is returned when reopening a document that isn't approved
+	errApprovalNotApproved = errors.New("the file isn't approved")
+)
+
+// ApprovalDecision is the decision of a reviewer
+type ApprovalDecision struct {
+	Decision  string `json:"decision"`
+	DecidedAt int64  `json:"decidedAt"`
+}
+
+// FileApproval is a request to review a document, sent to a list of reviewers.
+// The document is approved once all the reviewers approved it, and the review is closed as soon as one requests changes.
+type FileApproval struct {
+	ID          string                       `json:"id"`
+	FileID      string                       `json:"fileId"`
+	RequestedBy string                       `json:"requestedBy"`
+	RequestedAt int64                        `json:"requestedAt"`
+	Reviewers   []string                     `json:"reviewers"`
+	Decisions   map[string]*ApprovalDecision `json:"decisions"`
+	Status      string                       `json:"status"`
+
+	// PostID is the ID of the post with the review buttons
+	PostID string `json:"postId"`
+
+	// ReopenedBy is the ID of the user who reopened the approved document
+	ReopenedBy string `json:"reopenedBy,omitempty"`
+	ReopenedAt int64  `json:"reopenedAt,omitempty"`
+}
+
+func getApprovalKey(fileID string) string {
+	return approvalKeyPrefix + fileID
+}
+
+// isReviewer checks if the user was asked to review the document
+func (a *FileApproval) isReviewer(userID string) bool {
+	for _, reviewer := range a.Reviewers {
+		if reviewer == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// getFileApproval returns the last approval requested for the file, or nil if none was requested
+func (p *Plugin) getFileApproval(fileID string) (*FileApproval, error) {
+	data, appErr := p.API.KVGet(getApprovalKey(fileID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the file approval from KV store")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	approval := &FileApproval{}
+	if err := json.Unmarshal(data, approval); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the file approval")
+	}
+	return approval, nil
+}
+
+// isFileApproved checks if the file was approved
+func (p *Plugin) isFileApproved(fileID string) (bool, error) {
+	approval, err := p.getFileApproval(fileID)
+	if err != nil {
+		return false, err
+	}
+	return approval != nil && approval.Status == ApprovalStatusApproved, nil
+}
+
+// requestFileApproval asks the reviewers to review the file, in the thread of its post
+func (p *Plugin) requestFileApproval(fileInfo *model.FileInfo, post *model.Post, requestedBy string, reviewers []*model.User) (*FileApproval, error) {
+	approval := &FileApproval{
+		ID:          model.NewId(),
+		FileID:      fileInfo.Id,
+		RequestedBy: requestedBy,
+		RequestedAt: model.GetMillis(),
+		Decisions:   map[string]*ApprovalDecision{},
+		Status:      ApprovalStatusPending,
+	}
+	for _, reviewer := range reviewers {
+		approval.Reviewers = append(approval.Reviewers, reviewer.Id)
+	}
+
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the file approval")
+	}
+
+	// only one review can be pending, and an approved document stays approved until it's reopened
+	if err := p.kvAtomicUpdate(getApprovalKey(fileInfo.Id), func(current []byte) ([]byte, error) {
+		if current != nil {
+			existing := &FileApproval{}
+			if err := json.Unmarshal(current, existing); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal the file approval")
+			}
+			switch existing.Status {
+			case ApprovalStatusPending:
+				return nil, errors.New("a review of the file is already pending")
+			case ApprovalStatusApproved:
+				return nil, errors.New("the file is already approved")
+			}
+		}
+		return data, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	reviewPost := &model.Post{
+		ChannelId: post.ChannelId,
+		RootId:    getThreadRootID(post),
+		UserId:    requestedBy,
+	}
+	p.setApprovalAttachment(reviewPost, fileInfo, approval)
+
+	reviewPost, appErr := p.API.CreatePost(reviewPost)
+	if appErr != nil {
+		// don't leave a pending review nobody can decide on
+		_ = p.API.KVDelete(getApprovalKey(fileInfo.Id))
+		return nil, errors.Wrap(appErr, "failed to post the review request")
+	}
+
+	approval.PostID = reviewPost.Id
+	if _, err := p.updateFileApproval(fileInfo.Id, func(current *FileApproval) error {
+		current.PostID = reviewPost.Id
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return approval, nil
+}
+
+// updateFileApproval atomically applies update to the approval of the file
+func (p *Plugin) updateFileApproval(fileID string, update func(approval *FileApproval) error) (*FileApproval, error) {
+	approval := &FileApproval{}
+	err := p.kvAtomicUpdate(getApprovalKey(fileID), func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, errors.New("no approval was requested for the file")
+		}
+
+		approval = &FileApproval{}
+		if err := json.Unmarshal(data, approval); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal the file approval")
+		}
+
+		if err := update(approval); err != nil {
+			return nil, err
+		}
+		return json.Marshal(approval)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// decideFileApproval records the decision of a reviewer and updates the status of the review
+func (p *Plugin) decideFileApproval(fileID, approvalID, userID, decision string) (*FileApproval, error) {
+	return p.updateFileApproval(fileID, func(approval *FileApproval) error {
+		if approval.ID != approvalID || approval.Status != ApprovalStatusPending {
+			return errApprovalClosed
+		}
+
+		if !approval.isReviewer(userID) {
+			return errors.New("you were not asked to review this file")
+		}
+
+		approval.Decisions[userID] = &ApprovalDecision{Decision: decision, DecidedAt: model.GetMillis()}
+		if decision == ApprovalDecisionRequestChanges {
+			approval.Status = ApprovalStatusChangesRequested
+			return nil
+		}
+
+		for _, reviewer := range approval.Reviewers {
+			if approval.Decisions[reviewer] == nil || approval.Decisions[reviewer].Decision != ApprovalDecisionApprove {
+				return nil
+			}
+		}
+		approval.Status = ApprovalStatusApproved
+		return nil
+	})
+}
+
+// canReopenFileApproval checks if the user can reopen the approved file for changes:
+// the reviewers who approved it can take their approval back, and the channel admins can reopen any file.
+func (p *Plugin) canReopenFileApproval(approval *FileApproval, userID, channelID string) bool {
+	if decision := approval.Decisions[userID]; decision != nil && decision.Decision == ApprovalDecisionApprove {
+		return true
+	}
+	return p.isChannelAdmin(userID, channelID)
+}
+
+// reopenFileApproval reopens the approved file for changes, a new review can then be requested
+func (p *Plugin) reopenFileApproval(fileID, approvalID, userID string) (*FileApproval, error) {
+	return p.updateFileApproval(fileID, func(approval *FileApproval) error {
+		if approval.ID != approvalID || approval.Status != ApprovalStatusApproved {
+			return errApprovalNotApproved
+		}
+
+		approval.Status = ApprovalStatusReopened
+		approval.ReopenedBy = userID
+		approval.ReopenedAt = model.GetMillis()
+		return nil
+	})
+}
+
+// getUsernames returns the mentions of the users
+func (p *Plugin) getUsernames(userIDs []string) string {
+	mentions := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			mentions = append(mentions, "@"+user.Username)
+		}
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// setApprovalAttachment sets the message and buttons of the review post, following the status of the review
+func (p *Plugin) setApprovalAttachment(post *model.Post, fileInfo *model.FileInfo, approval *FileApproval) {
+	attachment := &model.SlackAttachment{
+		Title: "Review requested: " + fileInfo.Name,
+		Text:  p.getUsernames(approval.Reviewers) + ", please review **" + fileInfo.Name + "**.",
+	}
+
+	var approvedBy []string
+	for _, reviewer := range approval.Reviewers {
+		if decision := approval.Decisions[reviewer]; decision != nil && decision.Decision == ApprovalDecisionApprove {
+			approvedBy = append(approvedBy, reviewer)
+		}
+	}
+	if len(approvedBy) > 0 {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: "Approved by", Value: p.getUsernames(approvedBy)})
+	}
+
+	switch approval.Status {
+	case ApprovalStatusPending:
+		integrationURL := "/plugins/" + root.Manifest.Id + "/api/v1/files/" + fileInfo.Id + "/approval/decision"
+		attachment.Actions = []*model.PostAction{
+			{
+				Id:    "approve",
+				Type:  model.POST_ACTION_TYPE_BUTTON,
+				Name:  "Approve",
+				Style: "good",
+				Integration: &model.PostActionIntegration{
+					URL:     integrationURL,
+					Context: map[string]interface{}{"approval_id": approval.ID, "decision": ApprovalDecisionApprove},
+				},
+			},
+			{
+				Id:    "requestchanges",
+				Type:  model.POST_ACTION_TYPE_BUTTON,
+				Name:  "Request changes",
+				Style: "danger",
+				Integration: &model.PostActionIntegration{
+					URL:     integrationURL,
+					Context: map[string]interface{}{"approval_id": approval.ID, "decision": ApprovalDecisionRequestChanges},
+				},
+			},
+		}
+	case ApprovalStatusApproved:
+		attachment.Color = "#3db887"
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: "Status", Value: "Approved, the file is now read-only."})
+		attachment.Actions = []*model.PostAction{
+			{
+				Id:   "reopen",
+				Type: model.POST_ACTION_TYPE_BUTTON,
+				Name: "Reopen for changes",
+				Integration: &model.PostActionIntegration{
+					URL:     "/plugins/" + root.Manifest.Id + "/api/v1/files/" + fileInfo.Id + "/approval/reopen",
+					Context: map[string]interface{}{"approval_id": approval.ID},
+				},
+			},
+		}
+	case ApprovalStatusChangesRequested:
+		attachment.Color = "#d24b4e"
+		for userID, decision := range approval.Decisions {
+			if decision.Decision == ApprovalDecisionRequestChanges {
+				attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: "Status", Value: "Changes requested by " + p.getUsernames([]string{userID}) + "."})
+			}
+		}
+	case ApprovalStatusReopened:
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: "Status", Value: "Reopened for changes by " + p.getUsernames([]string{approval.ReopenedBy}) + "."})
+	}
+
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+}
+
+// postApprovalOutcome posts the outcome of the review in the thread of the file
+func (p *Plugin) postApprovalOutcome(fileInfo *model.FileInfo, post *model.Post, approval *FileApproval, userID string) error {
+	var message string
+	switch approval.Status {
+	case ApprovalStatusApproved:
+		message = ":white_check_mark: **" + fileInfo.Name + "** was approved by " + p.getUsernames(approval.Reviewers) + ". The file is now read-only."
+	case ApprovalStatusChangesRequested:
+		message = ":memo: " + p.getUsernames([]string{userID}) + " requested changes on **" + fileInfo.Name + "**."
+	case ApprovalStatusReopened:
+		message = ":arrows_counterclockwise: " + p.getUsernames([]string{userID}) + " reopened **" + fileInfo.Name + "** for changes. The file can be edited again."
+	default:
+		return nil
+	}
+
+	return p.replyInThread(post, userID, message)
+}
+
+// applyApproval opens the approved files in view-only mode
+func (p *Plugin) applyApproval(access *FileAccess) {
+	if !access.CanComment {
+		return
+	}
+
+	approved, err := p.isFileApproved(access.FileInfo.Id)
+	if err != nil {
+		// don't let a storage error unlock an approved file
+		p.API.LogError("Failed to get the file approval.", "FileID", access.FileInfo.Id, "Error", err.Error())
+		approved = true
+	}
+
+	if !approved {
+		return
+	}
+
+	access.restrict("approval", "the file was approved")
+	access.Notice = "This file was approved and can't be changed."
+	access.CanComment = false
+	access.CanEdit = false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestDecideFileApproval(t *testing.T) {
+	type decision struct {
+		// reviewer is the index of the reviewer, -1 for a user who isn't a reviewer
+		reviewer int
+		decision string
+	}
+
+	tests := []struct {
+		name      string
+		reviewers int
+		decisions []decision
+
+		expectedStatus string
+		// expectedErr tells if the last decision isn't recorded
+		expectedErr bool
+	}{
+		{"no decision", 2, nil, ApprovalStatusPending, false},
+		{"approved by one of two reviewers", 2, []decision{{0, ApprovalDecisionApprove}}, ApprovalStatusPending, false},
+		{"approved by all the reviewers", 2, []decision{{0, ApprovalDecisionApprove}, {1, ApprovalDecisionApprove}}, ApprovalStatusApproved, false},
+		{"changes requested", 2, []decision{{0, ApprovalDecisionApprove}, {1, ApprovalDecisionRequestChanges}}, ApprovalStatusChangesRequested, false},
+		{"decision after changes were requested", 2, []decision{{0, ApprovalDecisionRequestChanges}, {1, ApprovalDecisionApprove}}, ApprovalStatusChangesRequested, true},
+		{"decision after the approval", 1, []decision{{0, ApprovalDecisionApprove}, {0, ApprovalDecisionRequestChanges}}, ApprovalStatusApproved, true},
+		{"decision of a user who isn't a reviewer", 1, []decision{{-1, ApprovalDecisionApprove}}, ApprovalStatusPending, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			_, post, fileInfo := api.addFile(model.NewId())
+
+			var reviewers []*model.User
+			for i := 0; i < test.reviewers; i++ {
+				reviewers = append(reviewers, api.addUser("system_user"))
+			}
+			approval, err := p.requestFileApproval(fileInfo, post, post.UserId, reviewers)
+			if err != nil {
+				t.Fatalf("failed to request the approval: %v", err)
+			}
+
+			for i, decision := range test.decisions {
+				userID := model.NewId()
+				if decision.reviewer >= 0 {
+					userID = reviewers[decision.reviewer].Id
+				}
+				_, err = p.decideFileApproval(fileInfo.Id, approval.ID, userID, decision.decision)
+				if i < len(test.decisions)-1 && err != nil {
+					t.Fatalf("failed to record the decision %d: %v", i, err)
+				}
+			}
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected the last decision to fail: %v, got %v", test.expectedErr, err)
+			}
+
+			current, err := p.getFileApproval(fileInfo.Id)
+			if err != nil {
+				t.Fatalf("failed to get the approval: %v", err)
+			}
+			if current.Status != test.expectedStatus {
+				t.Errorf("expected the status %s, got %s", test.expectedStatus, current.Status)
+			}
+		})
+	}
+}
+
+func TestReopenApproval(t *testing.T) {
+	member := []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE}
+	admin := append([]*model.Permission{model.PERMISSION_MANAGE_CHANNEL_ROLES}, member...)
+
+	const (
+		approver  = "approver"
+		requester = "requester"
+		other     = "other"
+	)
+
+	tests := []struct {
+		name string
+
+		// user is the user reopening the file
+		user        string
+		permissions []*model.Permission
+		approved    bool
+
+		expectedCode   int
+		expectedStatus string
+	}{
+		{"reviewer who approved the file", approver, member, true, http.StatusOK, ApprovalStatusReopened},
+		{"channel admin", other, admin, true, http.StatusOK, ApprovalStatusReopened},
+		{"requester", requester, member, true, http.StatusForbidden, ApprovalStatusApproved},
+		{"other member", other, member, true, http.StatusForbidden, ApprovalStatusApproved},
+		{"pending review", other, admin, false, http.StatusOK, ApprovalStatusPending},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			users := map[string]*model.User{
+				approver:  api.addUser("system_user"),
+				requester: api.addUser("system_user"),
+				other:     api.addUser("system_user"),
+			}
+			channel, post, fileInfo := api.addFile(users[requester].Id)
+			for name, user := range users {
+				if name == test.user {
+					api.grant(user.Id, channel.Id, test.permissions...)
+				} else {
+					api.grant(user.Id, channel.Id, member...)
+				}
+			}
+
+			approval, err := p.requestFileApproval(fileInfo, post, users[requester].Id, []*model.User{users[approver]})
+			if err != nil {
+				t.Fatalf("failed to request the approval: %v", err)
+			}
+			if test.approved {
+				if _, err = p.decideFileApproval(fileInfo.Id, approval.ID, users[approver].Id, ApprovalDecisionApprove); err != nil {
+					t.Fatalf("failed to approve the file: %v", err)
+				}
+			}
+
+			body, _ := json.Marshal(&model.PostActionIntegrationRequest{Context: map[string]interface{}{"approval_id": approval.ID}})
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/files/"+fileInfo.Id+"/approval/reopen", bytes.NewReader(body))
+			r.Header.Set(HeaderMattermostUserID, users[test.user].Id)
+			r = mux.SetURLVars(r, map[string]string{"fileID": fileInfo.Id})
+			w := httptest.NewRecorder()
+			p.reopenApproval(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("expected the status code %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+
+			current, err := p.getFileApproval(fileInfo.Id)
+			if err != nil {
+				t.Fatalf("failed to get the approval: %v", err)
+			}
+			if current.Status != test.expectedStatus {
+				t.Errorf("expected the status %s, got %s", test.expectedStatus, current.Status)
+			}
+
+			// only the approval makes the file read-only
+			access, err := p.getFileAccess(users[requester].Id, fileInfo, post)
+			if err != nil {
+				t.Fatalf("failed to get the file access: %v", err)
+			}
+			if expected := test.expectedStatus != ApprovalStatusApproved; access.CanEdit != expected {
+				t.Errorf("expected the requester's CanEdit %v, got %v", expected, access.CanEdit)
+			}
+		})
+	}
+}
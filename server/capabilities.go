@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// capabilitiesAppName and capabilitiesActionName identify the capabilities URL in the discovery
+	capabilitiesAppName    = "Capabilities"
+	capabilitiesActionName = "getinfo"
+
+	// capabilitiesPath is the path of the capabilities, used if the discovery doesn't list it
+	capabilitiesPath = "/hosting/capabilities"
+)
+
+// Capabilities are the features supported by the Collabora Online server, from <WOPI>/hosting/capabilities.
+// The plugin reads the flags it uses, the whole document is passed through to the webapp.
+type Capabilities struct {
+	HasTemplateSaveAs bool   `json:"hasTemplateSaveAs"`
+	ProductName       string `json:"productName"`
+	ProductVersion    string `json:"productVersion"`
+
+	// document is the capabilities JSON, as returned by the server
+	document json.RawMessage
+}
+
+// MarshalJSON returns the capabilities JSON as returned by the server, with the flags the plugin doesn't use
+func (c *Capabilities) MarshalJSON() ([]byte, error) {
+	return c.document, nil
+}
+
+// SupportsTemplateSaveAs checks if the Collabora Online server can save a template as a new document when it is opened.
+// Servers whose capabilities are unknown are assumed not to support it.
+func (c *Capabilities) SupportsTemplateSaveAs() bool {
+	return c != nil && c.HasTemplateSaveAs
+}
+
+// fetchCapabilities fetches the capabilities JSON from the Collabora Online server
+func (p *Plugin) fetchCapabilities(capabilitiesURL string) ([]byte, error) {
+	resp, err := p.getDiscoveryHTTPClient().Get(capabilitiesURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request the capabilities")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("the capabilities request failed with status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the capabilities")
+	}
+	return body, nil
+}
+
+// parseCapabilities parses the capabilities JSON, returning nil if the server didn't provide them
+func parseCapabilities(data []byte) (*Capabilities, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	capabilities := &Capabilities{}
+	if err := json.Unmarshal(data, capabilities); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the capabilities")
+	}
+	capabilities.document = append(json.RawMessage{}, data...)
+	return capabilities, nil
+}
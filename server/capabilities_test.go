@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	document := `{"convert-to":{"available":true,"endpoint":"/cool/convert-to"},"hasTemplateSaveAs":true,"hasZoteroSupport":true,"productName":"Collabora Online Development Edition","productVersion":"22.05.8.1"}`
+
+	tests := []struct {
+		name     string
+		document string
+
+		expectedJSON           string
+		expectedTemplateSaveAs bool
+	}{
+		{"document passed through", document, document, true},
+		{"no template save as", `{"productVersion":"6.4.0"}`, `{"productVersion":"6.4.0"}`, false},
+		{"capabilities not provided", "", "null", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			capabilities, err := parseCapabilities([]byte(test.document))
+			if err != nil {
+				t.Fatalf("failed to parse the capabilities: %v", err)
+			}
+
+			data, err := json.Marshal(capabilities)
+			if err != nil {
+				t.Fatalf("failed to marshal the capabilities: %v", err)
+			}
+			if string(data) != test.expectedJSON {
+				t.Errorf("expected %s, got %s", test.expectedJSON, data)
+			}
+			if capabilities.SupportsTemplateSaveAs() != test.expectedTemplateSaveAs {
+				t.Errorf("expected template save as %v", test.expectedTemplateSaveAs)
+			}
+		})
+	}
+}
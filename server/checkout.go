@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// checkoutKeyPrefix is the KV store key prefix used for the check-out of a file
+	checkoutKeyPrefix = "file_checkout_"
+
+	// checkoutLockIDPrefix prefixes the lock ID reported to Collabora Online while a file is checked out
+	checkoutLockIDPrefix = "checkout:"
+
+	// checkoutIndexKey is the KV store key of the IDs of the checked out files, read by the expiry job
+	checkoutIndexKey = "file_checkouts"
+
+	// checkoutExpiryInterval is how often the expired check-outs are checked in
+	checkoutExpiryInterval = time.Minute
+
+	// checkoutExpiryLockKey is the KV store key used to make sure only one server of the cluster checks in the expired check-outs
+	checkoutExpiryLockKey = "checkout_expiry_lock"
+)
+
+var (
+	// errFileCheckedOut is returned when checking out a file already checked out by another user
+	errFileCheckedOut = errors.New("the file is already checked out by another user")
+
+	// errFileLockedByAnotherUser is returned when checking out a file another user is editing
+	errFileLockedByAnotherUser = errors.New("the file is being edited by another user, it can be checked out once they close it")
+)
+
+// FileCheckout gives a user the exclusive right to edit a file until it is checked in, or the check-out expires
+type FileCheckout struct {
+	UserID       string `json:"userId"`
+	CheckedOutAt int64  `json:"checkedOutAt"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+func getCheckoutKey(fileID string) string {
+	return checkoutKeyPrefix + fileID
+}
+
+// isExpired checks if the check-out has expired but the file was not yet checked in
+func (c *FileCheckout) isExpired() bool {
+	return c.ExpiresAt < model.GetMillis()
+}
+
+// lockID returns the lock ID reported to Collabora Online for the sessions of the other users
+func (c *FileCheckout) lockID() string {
+	return checkoutLockIDPrefix + c.UserID
+}
+
+// getStoredFileCheckout returns the check-out of the file stored in KV store, even if it expired, and its data
+func (p *Plugin) getStoredFileCheckout(fileID string) (*FileCheckout, []byte, error) {
+	data, appErr := p.API.KVGet(getCheckoutKey(fileID))
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to get the file check-out from KV store")
+	}
+
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	checkout := &FileCheckout{}
+	if err := json.Unmarshal(data, checkout); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal the file check-out")
+	}
+	return checkout, data, nil
+}
+
+// getFileCheckout returns the current check-out of the file, or nil if the file isn't checked out or the check-out expired
+func (p *Plugin) getFileCheckout(fileID string) (*FileCheckout, error) {
+	checkout, _, err := p.getStoredFileCheckout(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkout == nil || checkout.isExpired() {
+		return nil, nil
+	}
+	return checkout, nil
+}
+
+// checkInExpiredFileCheckout checks in the expired check-out stored with data, and records it in the thread of the file.
+// Only the request deleting the expired check-out records it, when several ones see it at once.
+func (p *Plugin) checkInExpiredFileCheckout(fileInfo *model.FileInfo, post *model.Post, checkout *FileCheckout, data []byte) error {
+	deleted, appErr := p.API.KVCompareAndDelete(getCheckoutKey(fileInfo.Id), data)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to delete the expired file check-out from KV store")
+	}
+	if !deleted {
+		return nil
+	}
+
+	if err := p.updateCheckoutIndex(fileInfo.Id, false); err != nil {
+		p.API.LogWarn("Failed to remove the file from the check-out index.", "FileID", fileInfo.Id, "Error", err.Error())
+	}
+
+	message := ":unlock: **" + fileInfo.Name + "** was checked in automatically, the check-out of " + p.getUsernames([]string{checkout.UserID}) + " expired."
+	if err := p.replyInThread(post, checkout.UserID, message); err != nil {
+		p.API.LogWarn("Failed to record the automatic check-in of the file.", "FileID", fileInfo.Id, "Error", err.Error())
+	}
+	return nil
+}
+
+// getCheckoutIndex returns the IDs of the files with a stored check-out
+func (p *Plugin) getCheckoutIndex() ([]string, error) {
+	data, appErr := p.API.KVGet(checkoutIndexKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the check-out index from KV store")
+	}
+
+	var fileIDs []string
+	if data == nil {
+		return fileIDs, nil
+	}
+	if err := json.Unmarshal(data, &fileIDs); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the check-out index")
+	}
+	return fileIDs, nil
+}
+
+// updateCheckoutIndex adds the file to the check-out index, or removes it from the index
+func (p *Plugin) updateCheckoutIndex(fileID string, checkedOut bool) error {
+	return p.kvAtomicUpdate(checkoutIndexKey, func(data []byte) ([]byte, error) {
+		var fileIDs []string
+		if data != nil {
+			if err := json.Unmarshal(data, &fileIDs); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal the check-out index")
+			}
+		}
+
+		updated := make([]string, 0, len(fileIDs)+1)
+		for _, id := range fileIDs {
+			if id != fileID {
+				updated = append(updated, id)
+			}
+		}
+		if checkedOut {
+			updated = append(updated, fileID)
+		}
+		return json.Marshal(updated)
+	})
+}
+
+// checkOutFile gives the user the exclusive right to edit the file. Checking out a file again extends the check-out.
+func (p *Plugin) checkOutFile(fileInfo *model.FileInfo, post *model.Post, userID string) (*FileCheckout, error) {
+	current, currentData, err := p.getStoredFileCheckout(fileInfo.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// check in an expired check-out first, so that each cycle is recorded
+	if current != nil && current.isExpired() {
+		if err := p.checkInExpiredFileCheckout(fileInfo, post, current, currentData); err != nil {
+			return nil, err
+		}
+		current = nil
+	}
+	if current != nil && current.UserID != userID {
+		return nil, errFileCheckedOut
+	}
+
+	// the saves of the user would be rejected while the session of another user holds the WOPI lock
+	lock, _, err := p.lockManager.getLock(fileInfo.Id)
+	if err != nil {
+		return nil, err
+	}
+	if lock != nil && lock.UserID != userID {
+		return nil, errFileLockedByAnotherUser
+	}
+
+	now := time.Now()
+	checkout := &FileCheckout{
+		UserID:       userID,
+		CheckedOutAt: model.GetMillisForTime(now),
+		ExpiresAt:    model.GetMillisForTime(now.Add(p.getConfiguration().checkoutTimeout)),
+	}
+	if current != nil {
+		checkout.CheckedOutAt = current.CheckedOutAt
+	}
+
+	data, err := json.Marshal(checkout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the file check-out")
+	}
+
+	// index the file first, so that a check-out is never left out of the expiry job
+	if err := p.updateCheckoutIndex(fileInfo.Id, true); err != nil {
+		return nil, err
+	}
+
+	if err := p.kvAtomicUpdate(getCheckoutKey(fileInfo.Id), func(oldData []byte) ([]byte, error) {
+		if oldData == nil {
+			return data, nil
+		}
+
+		existing := &FileCheckout{}
+		if err := json.Unmarshal(oldData, existing); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal the file check-out")
+		}
+		if existing.UserID != userID && !existing.isExpired() {
+			return nil, errFileCheckedOut
+		}
+		return data, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		message := ":lock: " + p.getUsernames([]string{userID}) + " checked out **" + fileInfo.Name + "** for exclusive editing."
+		if err := p.replyInThread(post, userID, message); err != nil {
+			p.API.LogWarn("Failed to record the check-out of the file.", "FileID", fileInfo.Id, "Error", err.Error())
+		}
+	}
+
+	return checkout, nil
+}
+
+// checkInFile ends the check-out of the file, allowed to the user who checked it out and to the channel admins.
+// The comment is recorded in the thread of the file along with the check-in.
+func (p *Plugin) checkInFile(fileInfo *model.FileInfo, post *model.Post, userID, comment string) error {
+	current, err := p.getFileCheckout(fileInfo.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return errors.New("the file is not checked out")
+	}
+	if current.UserID != userID && !p.isChannelAdmin(userID, post.ChannelId) {
+		return errFileCheckedOut
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the file check-out")
+	}
+
+	deleted, appErr := p.API.KVCompareAndDelete(getCheckoutKey(fileInfo.Id), data)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to delete the file check-out from KV store")
+	}
+	if !deleted {
+		return errors.New("the check-out of the file changed, try again")
+	}
+
+	if err := p.updateCheckoutIndex(fileInfo.Id, false); err != nil {
+		p.API.LogWarn("Failed to remove the file from the check-out index.", "FileID", fileInfo.Id, "Error", err.Error())
+	}
+
+	message := ":unlock: " + p.getUsernames([]string{userID}) + " checked in **" + fileInfo.Name + "**."
+	if current.UserID != userID {
+		message = ":unlock: " + p.getUsernames([]string{userID}) + " checked in **" + fileInfo.Name + "**, checked out by " + p.getUsernames([]string{current.UserID}) + "."
+	}
+	if comment != "" {
+		message += "\n> " + strings.ReplaceAll(comment, "\n", "\n> ")
+	}
+	if err := p.replyInThread(post, userID, message); err != nil {
+		p.API.LogWarn("Failed to record the check-in of the file.", "FileID", fileInfo.Id, "Error", err.Error())
+	}
+	return nil
+}
+
+// applyCheckout opens a checked out file in view-only mode for everyone but the user who checked it out
+func (p *Plugin) applyCheckout(access *FileAccess) {
+	if !access.CanView {
+		return
+	}
+
+	checkout, err := p.getFileCheckout(access.FileInfo.Id)
+	if err != nil {
+		// don't let a storage error break the exclusivity of a check-out
+		p.API.LogError("Failed to get the file check-out.", "FileID", access.FileInfo.Id, "Error", err.Error())
+		checkout = &FileCheckout{}
+	}
+
+	access.Checkout = checkout
+	if checkout == nil || checkout.UserID == access.User.Id || !access.CanComment {
+		return
+	}
+
+	access.restrict("check-out", "the file is checked out by another user")
+	access.Notice = "This file is checked out by " + p.getUsernames([]string{checkout.UserID}) + "."
+	if checkout.UserID == "" {
+		access.Notice = "This file is checked out by another user."
+	}
+	access.CanComment = false
+	access.CanEdit = false
+}
+
+// runCheckoutExpiryJob checks in the expired check-outs, so that their automatic check-in is recorded in time.
+// It stops when the stop channel is closed.
+func (p *Plugin) runCheckoutExpiryJob(stop <-chan struct{}) {
+	ticker := time.NewTicker(checkoutExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// make sure only one server of the cluster checks in the expired check-outs
+			locked, appErr := p.API.KVSetWithOptions(checkoutExpiryLockKey, []byte("locked"), model.PluginKVSetOptions{
+				Atomic:          true,
+				ExpireInSeconds: int64(checkoutExpiryInterval / time.Second),
+			})
+			if appErr != nil {
+				p.API.LogError("Failed to acquire the check-out expiry lock.", "Error", appErr.Error())
+				continue
+			}
+			if !locked {
+				continue
+			}
+
+			if err := p.expireFileCheckouts(); err != nil {
+				p.API.LogError("Failed to check in the expired check-outs.", "Error", err.Error())
+			}
+		}
+	}
+}
+
+// expireFileCheckouts checks in the expired check-outs of the check-out index.
+// The check-outs of deleted files are dropped, the files that can't be read are retried on the next run.
+func (p *Plugin) expireFileCheckouts() error {
+	fileIDs, err := p.getCheckoutIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, fileID := range fileIDs {
+		checkout, data, err := p.getStoredFileCheckout(fileID)
+		if err != nil {
+			p.API.LogWarn("Failed to get the file check-out.", "FileID", fileID, "Error", err.Error())
+			continue
+		}
+
+		if checkout == nil {
+			// the file was checked in
+			if err := p.updateCheckoutIndex(fileID, false); err != nil {
+				p.API.LogWarn("Failed to remove the file from the check-out index.", "FileID", fileID, "Error", err.Error())
+			}
+			continue
+		}
+		if !checkout.isExpired() {
+			continue
+		}
+
+		deleted, err := p.isFileDeleted(fileID)
+		if err != nil {
+			p.API.LogWarn("Failed to check if the checked out file was deleted.", "FileID", fileID, "Error", err.Error())
+			continue
+		}
+		if deleted {
+			if _, appErr := p.API.KVCompareAndDelete(getCheckoutKey(fileID), data); appErr != nil {
+				p.API.LogWarn("Failed to delete the check-out of the deleted file.", "FileID", fileID, "Error", appErr.Error())
+				continue
+			}
+			if err := p.updateCheckoutIndex(fileID, false); err != nil {
+				p.API.LogWarn("Failed to remove the file from the check-out index.", "FileID", fileID, "Error", err.Error())
+			}
+			continue
+		}
+
+		fileInfo, err := p.getFileInfo(fileID)
+		if err != nil {
+			p.API.LogWarn("Failed to get the checked out file.", "FileID", fileID, "Error", err.Error())
+			continue
+		}
+
+		post, appErr := p.API.GetPost(fileInfo.PostId)
+		if appErr != nil {
+			p.API.LogWarn("Failed to get the post of the checked out file.", "FileID", fileID, "Error", appErr.Error())
+			continue
+		}
+
+		if err := p.checkInExpiredFileCheckout(fileInfo, post, checkout, data); err != nil {
+			p.API.LogWarn("Failed to check in the expired check-out of the file.", "FileID", fileID, "Error", err.Error())
+		}
+	}
+	return nil
+}
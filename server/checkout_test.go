@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+func TestCheckOutFile(t *testing.T) {
+	now := model.GetMillis()
+
+	tests := []struct {
+		name string
+
+		// current is the check-out of the file before the operation, by the user unless set
+		current *FileCheckout
+		// lockedBy is the user whose Collabora Online session locked the file
+		lockedBy string
+
+		expectedErr   error
+		expectedUser  string
+		expectedPosts int
+	}{
+		{name: "check out", expectedUser: "user", expectedPosts: 1},
+		{name: "extend the check-out", current: &FileCheckout{UserID: "user", ExpiresAt: now + time.Hour.Milliseconds()}, expectedUser: "user"},
+		{name: "checked out by another user", current: &FileCheckout{UserID: "other", ExpiresAt: now + time.Hour.Milliseconds()}, expectedErr: errFileCheckedOut, expectedUser: "other"},
+		{name: "expired check-out of another user", current: &FileCheckout{UserID: "other", ExpiresAt: now - 1}, expectedUser: "user", expectedPosts: 2},
+		{name: "edited by the user", lockedBy: "user", expectedUser: "user", expectedPosts: 1},
+		{name: "edited by another user", lockedBy: "other", expectedErr: errFileLockedByAnotherUser},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			p.setConfiguration(&configuration{checkoutTimeout: time.Hour})
+			_, post, fileInfo := api.addFile(model.NewId())
+
+			if test.current != nil {
+				api.kv[getCheckoutKey(fileInfo.Id)], _ = json.Marshal(test.current)
+			}
+			if test.lockedBy != "" {
+				if _, err := p.lockManager.Lock(fileInfo.Id, test.lockedBy, "A", ""); err != nil {
+					t.Fatalf("failed to lock the file: %v", err)
+				}
+			}
+
+			_, err := p.checkOutFile(fileInfo, post, "user")
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+			}
+
+			checkout, err := p.getFileCheckout(fileInfo.Id)
+			if err != nil {
+				t.Fatalf("failed to get the check-out: %v", err)
+			}
+			userID := ""
+			if checkout != nil {
+				userID = checkout.UserID
+			}
+			if userID != test.expectedUser {
+				t.Errorf("expected the file to be checked out by %q, got %q", test.expectedUser, userID)
+			}
+			if len(api.createdPosts) != test.expectedPosts {
+				t.Errorf("expected %d posts in the thread, got %d", test.expectedPosts, len(api.createdPosts))
+			}
+
+			index, err := p.getCheckoutIndex()
+			if err != nil {
+				t.Fatalf("failed to get the check-out index: %v", err)
+			}
+			if indexed := len(index) == 1 && index[0] == fileInfo.Id; indexed != (test.expectedUser == "user") {
+				t.Errorf("expected the file to be indexed: %v, got %v", test.expectedUser == "user", index)
+			}
+		})
+	}
+}
+
+func TestExpireFileCheckouts(t *testing.T) {
+	now := model.GetMillis()
+
+	tests := []struct {
+		name string
+
+		checkout *FileCheckout
+		// setup deletes the file or makes the API fail
+		setup func(api *testAPI, post *model.Post, fileInfo *model.FileInfo)
+
+		expectedCheckedOut bool
+		expectedIndexed    bool
+		expectedPosts      int
+	}{
+		{
+			name:               "check-out in progress",
+			checkout:           &FileCheckout{UserID: "user", ExpiresAt: now + time.Hour.Milliseconds()},
+			expectedCheckedOut: true,
+			expectedIndexed:    true,
+		},
+		{
+			name:          "expired check-out",
+			checkout:      &FileCheckout{UserID: "user", ExpiresAt: now - 1},
+			expectedPosts: 1,
+		},
+		{
+			name:     "expired check-out of a deleted file",
+			checkout: &FileCheckout{UserID: "user", ExpiresAt: now - 1},
+			setup:    func(api *testAPI, _ *model.Post, fileInfo *model.FileInfo) { delete(api.files, fileInfo.Id) },
+		},
+		{
+			name:     "expired check-out of a file that can't be read",
+			checkout: &FileCheckout{UserID: "user", ExpiresAt: now - 1},
+			setup: func(api *testAPI, _ *model.Post, _ *model.FileInfo) {
+				api.failures["GetFileInfo"] = model.NewAppError("GetFileInfo", "app.internal", nil, "", http.StatusInternalServerError)
+			},
+			expectedCheckedOut: true,
+			expectedIndexed:    true,
+		},
+		{
+			name: "checked in file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			_, post, fileInfo := api.addFile(model.NewId())
+
+			if test.checkout != nil {
+				api.kv[getCheckoutKey(fileInfo.Id)], _ = json.Marshal(test.checkout)
+			}
+			if err := p.updateCheckoutIndex(fileInfo.Id, true); err != nil {
+				t.Fatalf("failed to index the file: %v", err)
+			}
+			if test.setup != nil {
+				test.setup(api, post, fileInfo)
+			}
+
+			if err := p.expireFileCheckouts(); err != nil {
+				t.Fatalf("failed to expire the check-outs: %v", err)
+			}
+
+			if checkedOut := api.kv[getCheckoutKey(fileInfo.Id)] != nil; checkedOut != test.expectedCheckedOut {
+				t.Errorf("expected the check-out to be kept: %v, got %v", test.expectedCheckedOut, checkedOut)
+			}
+			index, err := p.getCheckoutIndex()
+			if err != nil {
+				t.Fatalf("failed to get the check-out index: %v", err)
+			}
+			if indexed := len(index) == 1; indexed != test.expectedIndexed {
+				t.Errorf("expected the file to be indexed: %v, got %v", test.expectedIndexed, index)
+			}
+			if len(api.createdPosts) != test.expectedPosts {
+				t.Errorf("expected %d posts in the thread, got %d", test.expectedPosts, len(api.createdPosts))
+			}
+
+		})
+	}
+}
@@ -1,19 +1,17 @@
 package main
 
 import (
-	"encoding/xml"
-	"io/ioutil"
+	"net"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 var (
-	// WopiFiles maps file extension with file action & url
-	WopiFiles map[string]WopiFile
-
 	// validEncryptionKeyChars ensures that the encryption key only contains letters and numbers
 	validEncryptionKeyChars = regexp.MustCompile("[^a-zA-Z0-9]+")
 
@@ -26,6 +24,16 @@ var (
 		"xlsx": "xlsxtemplate.xlsx",
 		"ods":  "template.ods",
 	}
+
+	// DocumentFromTemplateExt stores the extension of the document created from each template file extension
+	DocumentFromTemplateExt = map[string]string{
+		"ott":  "odt",
+		"ots":  "ods",
+		"otp":  "odp",
+		"dotx": "docx",
+		"xltx": "xlsx",
+		"potx": "pptx",
+	}
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -40,14 +48,68 @@ var (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	WOPIAddress   string
-	SkipSSLVerify bool
-	EncryptionKey string
+	WOPIAddress              string
+	SkipSSLVerify            bool
+	EncryptionKey            string
+	AccessTokenLifetime      string
+	KeyRotationGracePeriod   string
+	KeyRotationInterval      string
+	CheckoutTimeout          string
+	DiscoveryRefreshInterval string
+	ManualSaveAction         string
+	AutosaveAction           string
+	ExitSaveAction           string
+
+	EnableProofKeyValidation bool
+	WOPIAllowList            string
+	TrustedProxies           string
+	EditingPolicy            string
+	GuestAccess              string
+	ArchivedChannelAccess    string
+
+	// accessTokenLifetime is the parsed AccessTokenLifetime
+	accessTokenLifetime time.Duration
+
+	// keyRotationGracePeriod is the parsed KeyRotationGracePeriod
+	keyRotationGracePeriod time.Duration
+
+	// keyRotationInterval is the parsed KeyRotationInterval, zero if scheduled rotation is disabled
+	keyRotationInterval time.Duration
+
+	// checkoutTimeout is the parsed CheckoutTimeout
+	checkoutTimeout time.Duration
+
+	// discoveryRefreshInterval is the parsed DiscoveryRefreshInterval, zero if the periodic refresh is disabled
+	discoveryRefreshInterval time.Duration
+
+	// previousEncryptionKeys are the previous encryption keys still accepted to verify tokens
+	previousEncryptionKeys []*RetiredEncryptionKey
+
+	// wopiAllowList are the networks allowed to call the WOPI endpoints, resolved from WOPIAllowList
+	wopiAllowList []*net.IPNet
+
+	// trustedProxies are the proxies whose X-Forwarded-For header is trusted, resolved from TrustedProxies
+	trustedProxies []*net.IPNet
 }
 
+const (
+	// defaultAccessTokenLifetime is used when AccessTokenLifetime is not set
+	defaultAccessTokenLifetime = 10 * time.Hour
+
+	// defaultKeyRotationGracePeriod is used when KeyRotationGracePeriod is not set
+	defaultKeyRotationGracePeriod = 24 * time.Hour
+
+	// defaultCheckoutTimeout is used when CheckoutTimeout is not set
+	defaultCheckoutTimeout = 4 * time.Hour
+
+	// defaultDiscoveryRefreshInterval is used when DiscoveryRefreshInterval is not set
+	defaultDiscoveryRefreshInterval = time.Hour
+)
+
 // Clone deep copies the configuration
 func (c *configuration) Clone() *configuration {
-	return &configuration{WOPIAddress: c.WOPIAddress}
+	var clone = *c
+	return &clone
 }
 
 // ProcessConfiguration processes the config.
@@ -57,9 +119,89 @@ func (c *configuration) ProcessConfiguration() error {
 	c.WOPIAddress = strings.Trim(c.WOPIAddress, "/")
 	c.EncryptionKey = validEncryptionKeyChars.ReplaceAllString(c.EncryptionKey, "")
 
+	var err error
+	if c.accessTokenLifetime, err = parseDuration(c.AccessTokenLifetime, time.Minute, defaultAccessTokenLifetime); err != nil || c.accessTokenLifetime == 0 {
+		return errors.New("AccessTokenLifetime must be a positive number of minutes")
+	}
+
+	if c.keyRotationGracePeriod, err = parseDuration(c.KeyRotationGracePeriod, time.Hour, defaultKeyRotationGracePeriod); err != nil {
+		return errors.New("KeyRotationGracePeriod must be a number of hours")
+	}
+
+	if c.keyRotationInterval, err = parseDuration(c.KeyRotationInterval, 24*time.Hour, 0); err != nil {
+		return errors.New("KeyRotationInterval must be a number of days")
+	}
+
+	if c.checkoutTimeout, err = parseDuration(c.CheckoutTimeout, time.Minute, defaultCheckoutTimeout); err != nil || c.checkoutTimeout == 0 {
+		return errors.New("CheckoutTimeout must be a positive number of minutes")
+	}
+
+	if c.discoveryRefreshInterval, err = parseDuration(c.DiscoveryRefreshInterval, time.Minute, defaultDiscoveryRefreshInterval); err != nil {
+		return errors.New("DiscoveryRefreshInterval must be a number of minutes")
+	}
+
+	if c.EditingPolicy == "" {
+		c.EditingPolicy = EditingPolicyAll
+	}
+	if !isValidEditingPolicy(c.EditingPolicy) {
+		return errors.New("EditingPolicy must be one of: all, owner, channel_admin")
+	}
+
+	for _, setting := range []struct {
+		name         string
+		value        *string
+		defaultValue string
+	}{
+		{"GuestAccess", &c.GuestAccess, RestrictedAccessView},
+		{"ArchivedChannelAccess", &c.ArchivedChannelAccess, RestrictedAccessView},
+	} {
+		if *setting.value == "" {
+			*setting.value = setting.defaultValue
+		}
+		if *setting.value != RestrictedAccessView && *setting.value != RestrictedAccessDeny {
+			return errors.New(setting.name + " must be one of: view, deny")
+		}
+	}
+
+	for _, setting := range []struct {
+		name         string
+		value        *string
+		defaultValue string
+	}{
+		{"ManualSaveAction", &c.ManualSaveAction, SaveActionUpdatePost},
+		{"AutosaveAction", &c.AutosaveAction, SaveActionSilent},
+		{"ExitSaveAction", &c.ExitSaveAction, SaveActionUpdatePost},
+	} {
+		if *setting.value == "" {
+			*setting.value = setting.defaultValue
+		}
+		if !isValidSaveAction(*setting.value) {
+			return errors.New(setting.name + " must be one of: silent, update_post, notify")
+		}
+	}
+
 	return nil
 }
 
+// parseDuration parses a setting holding a non-negative number of units, returning defaultValue if the setting is empty
+func parseDuration(value string, unit time.Duration, defaultValue time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if number < 0 {
+		return 0, errors.New("negative value")
+	}
+
+	return time.Duration(number) * unit, nil
+}
+
 // IsValid checks if all needed fields are set.
 func (c *configuration) IsValid() error {
 	if !strings.HasPrefix(c.WOPIAddress, "http") {
@@ -113,40 +255,3 @@ func (p *Plugin) setConfiguration(configuration *configuration) {
 
 	p.configuration = configuration
 }
-
-// LoadWopiFileInfo loads the WOPI file data to memory
-func (p *Plugin) LoadWopiFileInfo(wopiAddress string) error {
-	client := p.GetHTTPClient()
-	resp, err := client.Get(wopiAddress + "/hosting/discovery")
-	if err != nil {
-		p.API.LogError("WOPI request error. Please check the WOPI address.", err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		p.API.LogError("WOPI request error. Failed to read WOPI request body. Please check the WOPI address.", err.Error())
-		return err
-	}
-
-	// wopiData contains the XML from <WOPI>/hosting/discovery
-	var wopiData WopiDiscovery
-	if err := xml.Unmarshal(body, &wopiData); err != nil {
-		p.API.LogError("WOPI request error. Failed to unmarshal WOPI XML. Please check the WOPI address.", err.Error())
-		return err
-	}
-
-	WopiFiles = make(map[string]WopiFile)
-	for _, app := range wopiData.NetZone.App {
-		for _, action := range app.Action {
-			ext := strings.ToLower(action.Ext)
-			if ext == "" || ext == "png" || ext == "jpg" || ext == "jpeg" || ext == "gif" {
-				continue
-			}
-			WopiFiles[ext] = WopiFile{action.URLSrc, action.Name}
-		}
-	}
-
-	p.API.LogInfo("WOPI file info loaded successfully!", "wopiFiles", WopiFiles)
-	return nil
-}
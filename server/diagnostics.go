@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	root "github.com/CollaboraOnline/collabora-mattermost"
+)
+
+const (
+	DiagnosticStatusOK      = "ok"
+	DiagnosticStatusWarning = "warning"
+	DiagnosticStatusError   = "error"
+	DiagnosticStatusSkipped = "skipped"
+
+	// diagnosticsTimeout bounds every request made by the diagnostics, so that an unreachable server doesn't block them
+	diagnosticsTimeout = 10 * time.Second
+
+	// certificateExpiryWarning is how long before the expiry of its certificate the diagnostics warn about it
+	certificateExpiryWarning = 14 * 24 * time.Hour
+
+	// clockSkewWarning is the clock difference with Collabora Online the diagnostics warn about
+	clockSkewWarning = time.Minute
+)
+
+// diagnosticStatusSeverity orders the statuses, the status of a report is the most severe of its checks
+var diagnosticStatusSeverity = map[string]int{
+	DiagnosticStatusSkipped: 0,
+	DiagnosticStatusOK:      1,
+	DiagnosticStatusWarning: 2,
+	DiagnosticStatusError:   3,
+}
+
+// DiagnosticCheck is the result of one check of the connection with Collabora Online
+type DiagnosticCheck struct {
+	Name    string            `json:"name"`
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// DiagnosticsReport lists the checks of the connection with Collabora Online, run with the saved configuration
+type DiagnosticsReport struct {
+	Status      string             `json:"status"`
+	WOPIAddress string             `json:"wopi_address"`
+	RanAt       int64              `json:"ran_at"`
+	Checks      []*DiagnosticCheck `json:"checks"`
+}
+
+// add adds the check to the report
+func (r *DiagnosticsReport) add(check *DiagnosticCheck) {
+	r.Checks = append(r.Checks, check)
+	if diagnosticStatusSeverity[check.Status] > diagnosticStatusSeverity[r.Status] {
+		r.Status = check.Status
+	}
+}
+
+func newDiagnosticCheck(name, status, message string) *DiagnosticCheck {
+	return &DiagnosticCheck{Name: name, Status: status, Message: message, Details: map[string]string{}}
+}
+
+// runDiagnostics checks the connection with Collabora Online, without changing the current discovery
+func (p *Plugin) runDiagnostics() *DiagnosticsReport {
+	config := p.getConfiguration()
+	report := &DiagnosticsReport{Status: DiagnosticStatusSkipped, WOPIAddress: config.WOPIAddress, RanAt: model.GetMillis()}
+
+	report.add(p.checkTLS(config))
+	discoveryCheck, discovery, serverTime := p.checkDiscovery(config)
+	report.add(discoveryCheck)
+	report.add(p.checkCapabilities(discovery))
+	report.add(p.checkWopiURLFromMattermost(config))
+	report.add(checkClockSkew(config, serverTime))
+	report.add(p.checkTokenSigning(config))
+	return report
+}
+
+// getDiagnosticsHTTPClient returns the HTTP client of the plugin, with a timeout
+func (p *Plugin) getDiagnosticsHTTPClient() *http.Client {
+	client := p.GetHTTPClient()
+	client.Timeout = diagnosticsTimeout
+	return client
+}
+
+// checkTLS checks the certificate of the Collabora Online server, even if its verification is disabled
+func (p *Plugin) checkTLS(config *configuration) *DiagnosticCheck {
+	wopiURL, err := url.Parse(config.WOPIAddress)
+	if err != nil || wopiURL.Hostname() == "" {
+		return newDiagnosticCheck("tls", DiagnosticStatusSkipped, "The Collabora Online URL isn't valid.")
+	}
+
+	if wopiURL.Scheme != "https" {
+		return newDiagnosticCheck("tls", DiagnosticStatusWarning, "The connection with Collabora Online isn't encrypted, use an https:// URL.")
+	}
+
+	port := wopiURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: diagnosticsTimeout}, "tcp", net.JoinHostPort(wopiURL.Hostname(), port), &tls.Config{ServerName: wopiURL.Hostname()})
+	if err != nil {
+		if config.SkipSSLVerify {
+			check := newDiagnosticCheck("tls", DiagnosticStatusWarning, "The certificate of Collabora Online isn't valid, it is accepted as the certificate verification is disabled.")
+			check.Details["error"] = err.Error()
+			return check
+		}
+		check := newDiagnosticCheck("tls", DiagnosticStatusError, "The certificate of Collabora Online can't be verified.")
+		check.Details["error"] = err.Error()
+		return check
+	}
+	defer conn.Close()
+
+	certificate := conn.ConnectionState().PeerCertificates[0]
+	check := newDiagnosticCheck("tls", DiagnosticStatusOK, "The certificate of Collabora Online is valid.")
+	check.Details["subject"] = certificate.Subject.String()
+	check.Details["issuer"] = certificate.Issuer.String()
+	check.Details["expires_at"] = certificate.NotAfter.UTC().Format(time.RFC3339)
+	if time.Until(certificate.NotAfter) < certificateExpiryWarning {
+		check.Status = DiagnosticStatusWarning
+		check.Message = "The certificate of Collabora Online expires soon."
+	}
+	if config.SkipSSLVerify {
+		check.Status = DiagnosticStatusWarning
+		check.Message = "The certificate of Collabora Online is valid, the certificate verification can be enabled again."
+	}
+	return check
+}
+
+// checkDiscovery fetches and parses the discovery of the Collabora Online server.
+// It returns the discovery and the time of the Collabora Online server, if they could be fetched.
+func (p *Plugin) checkDiscovery(config *configuration) (*DiagnosticCheck, *Discovery, time.Time) {
+	if config.WOPIAddress == "" {
+		return newDiagnosticCheck("discovery", DiagnosticStatusError, "The Collabora Online URL isn't configured."), nil, time.Time{}
+	}
+
+	start := time.Now()
+	resp, err := p.getDiagnosticsHTTPClient().Get(config.WOPIAddress + "/hosting/discovery")
+	if err != nil {
+		check := newDiagnosticCheck("discovery", DiagnosticStatusError, "Collabora Online can't be reached, check the Collabora Online URL.")
+		check.Details["error"] = err.Error()
+		return check, nil, time.Time{}
+	}
+	defer resp.Body.Close()
+
+	serverTime, _ := http.ParseTime(resp.Header.Get("Date"))
+	if resp.StatusCode != http.StatusOK {
+		check := newDiagnosticCheck("discovery", DiagnosticStatusError, "Collabora Online didn't return its discovery, check the Collabora Online URL.")
+		check.Details["status_code"] = resp.Status
+		return check, nil, serverTime
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		check := newDiagnosticCheck("discovery", DiagnosticStatusError, "The discovery of Collabora Online can't be read.")
+		check.Details["error"] = err.Error()
+		return check, nil, serverTime
+	}
+
+	discovery, err := parseDiscovery(&storedDiscovery{WOPIAddress: config.WOPIAddress, XML: data, FetchedAt: model.GetMillis()})
+	if err != nil {
+		check := newDiagnosticCheck("discovery", DiagnosticStatusError, "The discovery of Collabora Online isn't valid, check that the URL is the one of a Collabora Online server.")
+		check.Details["error"] = err.Error()
+		return check, nil, serverTime
+	}
+
+	check := newDiagnosticCheck("discovery", DiagnosticStatusOK, "The discovery of Collabora Online was fetched.")
+	check.Details["response_time"] = time.Since(start).Round(time.Millisecond).String()
+	check.Details["extensions"] = strconv.Itoa(len(discovery.Files))
+	check.Details["proof_keys"] = "yes"
+	if discovery.ProofKeys == nil {
+		check.Details["proof_keys"] = "no"
+		if config.EnableProofKeyValidation {
+			check.Status = DiagnosticStatusWarning
+			check.Message = "Collabora Online doesn't provide WOPI proof keys, its requests can't be verified."
+		}
+	}
+	if len(discovery.Files) == 0 {
+		check.Status = DiagnosticStatusError
+		check.Message = "The discovery of Collabora Online doesn't list any file type."
+	}
+	return check, discovery, serverTime
+}
+
+// checkCapabilities fetches and parses the capabilities of the Collabora Online server
+func (p *Plugin) checkCapabilities(discovery *Discovery) *DiagnosticCheck {
+	if discovery == nil {
+		return newDiagnosticCheck("capabilities", DiagnosticStatusSkipped, "The capabilities can't be checked without the discovery.")
+	}
+
+	client := p.getDiagnosticsHTTPClient()
+	resp, err := client.Get(discovery.CapabilitiesURL)
+	if err != nil {
+		check := newDiagnosticCheck("capabilities", DiagnosticStatusWarning, "The capabilities of Collabora Online can't be fetched, the features of the server are unknown.")
+		check.Details["error"] = err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("the capabilities request failed with status %d", resp.StatusCode)
+	}
+	var capabilities *Capabilities
+	if err == nil {
+		capabilities, err = parseCapabilities(data)
+	}
+	if err != nil || capabilities == nil {
+		check := newDiagnosticCheck("capabilities", DiagnosticStatusWarning, "Collabora Online doesn't provide its capabilities, the features of the server are unknown.")
+		if err != nil {
+			check.Details["error"] = err.Error()
+		}
+		return check
+	}
+
+	check := newDiagnosticCheck("capabilities", DiagnosticStatusOK, "The capabilities of Collabora Online were fetched.")
+	check.Details["product_name"] = capabilities.ProductName
+	check.Details["product_version"] = capabilities.ProductVersion
+	check.Details["template_save_as"] = strconv.FormatBool(capabilities.SupportsTemplateSaveAs())
+	return check
+}
+
+// checkWopiURLFromMattermost checks that Mattermost reaches the WOPI endpoints of the plugin through the Site URL.
+// Collabora Online can't be asked to call a URL, so whether it can reach them isn't tested;
+// only its addresses are checked against the WOPI allow-list.
+func (p *Plugin) checkWopiURLFromMattermost(config *configuration) *DiagnosticCheck {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	if siteURL == "" {
+		return newDiagnosticCheck("wopi_url_from_mattermost", DiagnosticStatusError, "The Site URL of Mattermost isn't configured, the plugin can't give Collabora Online the URL of its WOPI endpoints.")
+	}
+
+	check := newDiagnosticCheck("wopi_url_from_mattermost", DiagnosticStatusOK, "Mattermost reaches the WOPI endpoints of the plugin through the Site URL. This doesn't tell whether Collabora Online can reach them.")
+	check.Details["wopi_url"] = siteURL + "/plugins/" + root.Manifest.Id + "/api/v1/wopi"
+
+	// the ping goes through the WOPI allow-list and proof validation, which usually reject the requests of Mattermost,
+	// so the nonce echoed in the header tells that the request reached the WOPI endpoints of this plugin
+	nonce := model.NewId()
+	resp, err := p.getDiagnosticsHTTPClient().Get(p.getBaseAPIURL() + "/wopi/ping?nonce=" + nonce)
+	if err != nil {
+		check.Status = DiagnosticStatusError
+		check.Message = "Mattermost can't reach the WOPI endpoints of the plugin through the Site URL, check the Site URL and the proxies in front of Mattermost."
+		check.Details["error"] = err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get(HeaderWopiPingNonce) != nonce {
+		check.Status = DiagnosticStatusError
+		check.Message = "The Site URL doesn't lead to the WOPI endpoints of this plugin, check the Site URL and the proxies in front of Mattermost."
+		check.Details["status_code"] = resp.Status
+		return check
+	}
+	if resp.StatusCode != http.StatusOK {
+		check.Details["ping"] = "the request of Mattermost was rejected by the WOPI allow-list or the proof validation: " + resp.Status
+	}
+
+	wopiURL, err := url.Parse(config.WOPIAddress)
+	if err != nil || wopiURL.Hostname() == "" {
+		return check
+	}
+	if strings.HasPrefix(siteURL, "http://") && wopiURL.Scheme == "https" {
+		check.Status = DiagnosticStatusWarning
+		check.Message = "Mattermost and Collabora Online should use the same protocol, the files may fail to open."
+	}
+
+	// Collabora Online may still reach Mattermost from another address, behind a NAT for example
+	ips, err := net.LookupIP(wopiURL.Hostname())
+	if err != nil {
+		check.Details["allow_list"] = "the address of Collabora Online can't be resolved: " + err.Error()
+		return check
+	}
+	var rejected []string
+	for _, ip := range ips {
+		if !containsIP(config.wopiAllowList, ip) {
+			rejected = append(rejected, ip.String())
+		}
+	}
+	if len(rejected) > 0 {
+		check.Status = DiagnosticStatusWarning
+		check.Message = "Some addresses of Collabora Online aren't in the WOPI allow-list, their requests will be rejected."
+		check.Details["allow_list"] = "rejected addresses: " + strings.Join(rejected, ", ")
+		return check
+	}
+	check.Details["allow_list"] = "the addresses of Collabora Online are allowed"
+	return check
+}
+
+// checkClockSkew compares the clocks of Mattermost and Collabora Online, the WOPI proofs expire after wopiProofMaxAge
+// and are rejected if they are more than wopiProofMaxSkew in the future
+func checkClockSkew(config *configuration, serverTime time.Time) *DiagnosticCheck {
+	if serverTime.IsZero() {
+		return newDiagnosticCheck("clock_skew", DiagnosticStatusSkipped, "The time of Collabora Online is unknown.")
+	}
+
+	// the Date header has a precision of one second
+	skew := serverTime.Sub(time.Now()).Round(time.Second)
+	check := newDiagnosticCheck("clock_skew", DiagnosticStatusOK, "The clocks of Mattermost and Collabora Online are in sync.")
+	check.Details["skew"] = skew.String()
+	switch {
+	case skew <= -wopiProofMaxAge && config.EnableProofKeyValidation:
+		check.Status = DiagnosticStatusError
+		check.Message = "The clock of Collabora Online is late, its requests will be rejected as expired."
+	case skew > wopiProofMaxSkew && config.EnableProofKeyValidation:
+		check.Status = DiagnosticStatusError
+		check.Message = "The clock of Collabora Online is ahead, its requests will be rejected as coming from the future."
+	case skew > clockSkewWarning || skew < -clockSkewWarning:
+		check.Status = DiagnosticStatusWarning
+		check.Message = "The clocks of Mattermost and Collabora Online differ, synchronize them with NTP."
+	}
+	return check
+}
+
+// checkTokenSigning checks that the access tokens can be signed and verified with the encryption key
+func (p *Plugin) checkTokenSigning(config *configuration) *DiagnosticCheck {
+	if config.EncryptionKey == "" {
+		return newDiagnosticCheck("token_signing", DiagnosticStatusError, "The encryption key isn't configured, regenerate it in the plugin settings.")
+	}
+
+	userID, fileID := model.NewId(), model.NewId()
+	token, _ := p.EncodeToken(userID, fileID, WopiScopeView)
+	if token == "" {
+		return newDiagnosticCheck("token_signing", DiagnosticStatusError, "The access tokens can't be signed.")
+	}
+
+	wopiToken, ok := p.DecodeToken(token)
+	if !ok || wopiToken.UserID != userID || wopiToken.FileID != fileID || wopiToken.Scope != WopiScopeView {
+		return newDiagnosticCheck("token_signing", DiagnosticStatusError, "The access tokens can't be verified, check the encryption key.")
+	}
+
+	check := newDiagnosticCheck("token_signing", DiagnosticStatusOK, "The access tokens are signed and verified.")
+	check.Details["key_id"] = getKeyID(config.EncryptionKey)
+	check.Details["token_lifetime"] = config.accessTokenLifetime.String()
+	return check
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// discoveryKey is the KV store key of the last discovery fetched from Collabora Online
+	discoveryKey = "wopi_discovery"
+
+	// discoveryRefreshLockKey is the KV store key used to make sure only one server refreshes the discovery at a time
+	discoveryRefreshLockKey = "wopi_discovery_refresh_lock"
+
+	// discoverySyncInterval is how often the servers check if the discovery was refreshed by another server, or must be refreshed
+	discoverySyncInterval = time.Minute
+
+	// discoveryRequestTimeout bounds the requests for the discovery and the capabilities,
+	// so that an unresponsive Collabora Online server doesn't block the retries and the refreshes
+	discoveryRequestTimeout = 30 * time.Second
+)
+
+const (
+	// WopiActionEdit, WopiActionView and WopiActionViewComment are the discovery actions used to open the files
+	WopiActionEdit        = "edit"
+	WopiActionView        = "view"
+	WopiActionViewComment = "view_comment"
+)
+
+// wopiActionsByScope lists the actions that can open a file, in order of preference, for each token scope
+var wopiActionsByScope = map[string][]string{
+	WopiScopeEdit:    {WopiActionEdit, WopiActionViewComment, WopiActionView},
+	WopiScopeComment: {WopiActionViewComment, WopiActionEdit, WopiActionView},
+	WopiScopeView:    {WopiActionView, WopiActionViewComment, WopiActionEdit},
+}
+
+// Discovery is the parsed discovery of the Collabora Online server: the supported files and the proof keys
+type Discovery struct {
+	WOPIAddress string
+
+	// Files maps the file extensions to the apps handling them
+	Files map[string]WopiApp
+
+	// MimeTypes maps the MIME types to the apps handling them, for the files whose extension isn't listed
+	MimeTypes map[string]WopiApp
+
+	ProofKeys *ProofKeys
+
+	// CapabilitiesURL is the URL of the capabilities of the server
+	CapabilitiesURL string
+
+	// Capabilities are the features supported by the server, nil if the server didn't provide them
+	Capabilities *Capabilities
+
+	// Hash identifies the discovery XML and the capabilities, to tell when they changed
+	Hash      string
+	FetchedAt int64
+}
+
+// storedDiscovery is the discovery XML as stored in the KV store, shared by all the servers of the cluster
+type storedDiscovery struct {
+	WOPIAddress string `json:"wopiAddress"`
+	XML         []byte `json:"xml"`
+	FetchedAt   int64  `json:"fetchedAt"`
+
+	// Capabilities is the capabilities JSON, fetched along with the discovery
+	Capabilities []byte `json:"capabilities,omitempty"`
+}
+
+// DiscoveryRegistry holds the current discovery. It is replaced as a whole,
+// so the HTTP handlers always see a consistent discovery while it is refreshed.
+// The zero value is ready to use.
+type DiscoveryRegistry struct {
+	current atomic.Value
+}
+
+// Get returns the current discovery, empty if none was loaded yet
+func (r *DiscoveryRegistry) Get() *Discovery {
+	if discovery, ok := r.current.Load().(*Discovery); ok {
+		return discovery
+	}
+	return &Discovery{Files: map[string]WopiApp{}, MimeTypes: map[string]WopiApp{}}
+}
+
+// Swap replaces the current discovery
+func (r *DiscoveryRegistry) Swap(discovery *Discovery) {
+	r.current.Store(discovery)
+}
+
+// parseDiscovery parses the XML from <WOPI>/hosting/discovery, and the capabilities fetched along with it
+func parseDiscovery(stored *storedDiscovery) (*Discovery, error) {
+	var wopiData WopiDiscovery
+	if err := xml.Unmarshal(stored.XML, &wopiData); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the WOPI discovery XML")
+	}
+
+	capabilities, err := parseCapabilities(stored.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilitiesURL := stored.WOPIAddress + capabilitiesPath
+	files := make(map[string]WopiApp)
+	mimeTypes := make(map[string]WopiApp)
+	for _, app := range wopiData.NetZone.App {
+		for _, action := range app.Action {
+			ext := strings.ToLower(action.Ext)
+			switch {
+			case app.Name == capabilitiesAppName:
+				if action.Name == capabilitiesActionName {
+					capabilitiesURL = action.URLSrc
+				}
+			case ext == "png" || ext == "jpg" || ext == "jpeg" || ext == "gif":
+			case ext != "":
+				addWopiAction(files, ext, app.Name, action.Name, action.URLSrc)
+			case strings.Contains(app.Name, "/") && !strings.HasPrefix(app.Name, "image/"):
+				// the apps named after a MIME type have actions without extension
+				addWopiAction(mimeTypes, app.Name, app.Name, action.Name, action.URLSrc)
+			}
+		}
+	}
+
+	proofKeys, err := NewProofKeys(&wopiData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the WOPI proof keys")
+	}
+
+	hash := sha256.New()
+	hash.Write(stored.XML)
+	hash.Write(stored.Capabilities)
+	return &Discovery{
+		WOPIAddress:     stored.WOPIAddress,
+		Files:           files,
+		MimeTypes:       mimeTypes,
+		ProofKeys:       proofKeys,
+		CapabilitiesURL: capabilitiesURL,
+		Capabilities:    capabilities,
+		Hash:            hex.EncodeToString(hash.Sum(nil)),
+		FetchedAt:       stored.FetchedAt,
+	}, nil
+}
+
+// addWopiAction adds the action to the app handling the key, an extension or a MIME type.
+// When several apps list the same action, the first one is kept.
+func addWopiAction(apps map[string]WopiApp, key, appName, action, url string) {
+	app, ok := apps[key]
+	if !ok {
+		app = WopiApp{Name: appName, Actions: map[string]string{}}
+		apps[key] = app
+	}
+
+	if _, ok := app.Actions[action]; !ok {
+		app.Actions[action] = url
+	}
+}
+
+// getApp returns the app handling the file, found by its extension or else by its MIME type
+func (d *Discovery) getApp(fileInfo *model.FileInfo) (WopiApp, bool) {
+	if app, ok := d.Files[strings.ToLower(fileInfo.Extension)]; ok {
+		return app, true
+	}
+
+	app, ok := d.MimeTypes[fileInfo.MimeType]
+	return app, ok
+}
+
+// getAction returns the action and URL used to open a file with a token of the given scope,
+// falling back to the other actions the app supports
+func (a WopiApp) getAction(scope string) (string, string) {
+	for _, action := range wopiActionsByScope[scope] {
+		if url, ok := a.Actions[action]; ok {
+			return action, url
+		}
+	}
+	return "", ""
+}
+
+// getDiscoveryHTTPClient returns the HTTP client of the plugin, with a timeout
+func (p *Plugin) getDiscoveryHTTPClient() *http.Client {
+	client := p.GetHTTPClient()
+	client.Timeout = discoveryRequestTimeout
+	return client
+}
+
+// fetchDiscovery fetches the discovery XML from the Collabora Online server
+func (p *Plugin) fetchDiscovery(wopiAddress string) ([]byte, error) {
+	resp, err := p.getDiscoveryHTTPClient().Get(wopiAddress + "/hosting/discovery")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request the WOPI discovery")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("the WOPI discovery request failed with status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the WOPI discovery")
+	}
+	return body, nil
+}
+
+// getStoredDiscovery returns the last discovery fetched by any server of the cluster, or nil if there is none
+func (p *Plugin) getStoredDiscovery() (*storedDiscovery, error) {
+	data, appErr := p.API.KVGet(discoveryKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the WOPI discovery from KV store")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	stored := &storedDiscovery{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the WOPI discovery")
+	}
+	return stored, nil
+}
+
+// getLastKnownApps returns the apps of the last discovery fetched by any server of the cluster, without their URLs,
+// so that the webapp keeps handling the same files while Collabora Online can't be reached
+func (p *Plugin) getLastKnownApps() (files, mimeTypes map[string]WopiApp) {
+	stored, err := p.getStoredDiscovery()
+	if err != nil || stored == nil {
+		return nil, nil
+	}
+
+	discovery, err := parseDiscovery(stored)
+	if err != nil {
+		return nil, nil
+	}
+
+	withoutURLs := func(apps map[string]WopiApp) map[string]WopiApp {
+		result := make(map[string]WopiApp, len(apps))
+		for key, app := range apps {
+			result[key] = WopiApp{Name: app.Name, Actions: map[string]string{}}
+		}
+		return result
+	}
+	return withoutURLs(discovery.Files), withoutURLs(discovery.MimeTypes)
+}
+
+// storeDiscovery saves the discovery XML in the KV store, so the other servers of the cluster load it too
+func (p *Plugin) storeDiscovery(stored *storedDiscovery) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the WOPI discovery")
+	}
+
+	if appErr := p.API.KVSet(discoveryKey, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the WOPI discovery in KV store")
+	}
+	return nil
+}
+
+// refreshDiscovery fetches the discovery from the Collabora Online server, makes it current
+// and shares it with the other servers of the cluster. A failure is recorded in the discovery status.
+func (p *Plugin) refreshDiscovery(wopiAddress string) (*Discovery, error) {
+	discovery, err := p.fetchAndStoreDiscovery(wopiAddress)
+	if err != nil {
+		p.recordDiscoveryFailure(wopiAddress, err)
+		return nil, err
+	}
+
+	p.swapDiscovery(discovery)
+	p.resetDiscoveryStatus()
+	return discovery, nil
+}
+
+// fetchAndStoreDiscovery fetches the discovery and the capabilities from the Collabora Online server,
+// and shares them with the other servers of the cluster
+func (p *Plugin) fetchAndStoreDiscovery(wopiAddress string) (*Discovery, error) {
+	data, err := p.fetchDiscovery(wopiAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &storedDiscovery{WOPIAddress: wopiAddress, XML: data, FetchedAt: model.GetMillis()}
+	discovery, err := parseDiscovery(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	// the capabilities are optional, older servers don't provide them
+	if stored.Capabilities, err = p.fetchCapabilities(discovery.CapabilitiesURL); err != nil {
+		p.API.LogWarn("Failed to fetch the capabilities of Collabora Online.", "URL", discovery.CapabilitiesURL, "Error", err.Error())
+	} else if discovery, err = parseDiscovery(stored); err != nil {
+		p.API.LogWarn("Failed to parse the capabilities of Collabora Online.", "Error", err.Error())
+		stored.Capabilities = nil
+		if discovery, err = parseDiscovery(stored); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.storeDiscovery(stored); err != nil {
+		p.API.LogWarn("Failed to share the WOPI discovery with the other servers.", "Error", err.Error())
+	}
+	return discovery, nil
+}
+
+// swapDiscovery makes the discovery current. The discovery status is left to the caller,
+// as the discovery may be the last one fetched while Collabora Online can't be reached.
+func (p *Plugin) swapDiscovery(discovery *Discovery) {
+	if discovery.ProofKeys == nil {
+		p.API.LogWarn("Collabora Online doesn't provide WOPI proof keys. The requests from Collabora Online can't be verified.")
+	}
+
+	previous := p.discovery.Get()
+	p.discovery.Swap(discovery)
+
+	if previous.Hash != discovery.Hash {
+		p.API.LogInfo("WOPI file info loaded successfully!", "wopiFiles", discovery.Files, "capabilities", discovery.Capabilities)
+		p.publishDiscoveryUpdatedEvent(discovery)
+	}
+}
+
+// LoadWopiFileInfo loads the discovery of the Collabora Online server.
+// If the server can't be reached, the last discovery fetched from the same server is used meanwhile,
+// and the error is still returned so that the caller keeps retrying.
+func (p *Plugin) LoadWopiFileInfo(wopiAddress string) error {
+	_, err := p.refreshDiscovery(wopiAddress)
+	if err == nil {
+		return nil
+	}
+	p.API.LogError("WOPI request error. Please check the WOPI address.", "Error", err.Error())
+
+	stored, storedErr := p.getStoredDiscovery()
+	if storedErr != nil || stored == nil || stored.WOPIAddress != wopiAddress {
+		return err
+	}
+
+	discovery, parseErr := parseDiscovery(stored)
+	if parseErr != nil {
+		return err
+	}
+
+	if p.discovery.Get().FetchedAt < discovery.FetchedAt {
+		p.API.LogWarn("Using the last WOPI discovery fetched from Collabora Online.", "FetchedAt", time.Unix(0, stored.FetchedAt*int64(time.Millisecond)).UTC().String())
+		p.swapDiscovery(discovery)
+	}
+	return err
+}
+
+// recordDiscoveryFailure records why the discovery couldn't be loaded.
+// The discovery of a previous Collabora Online server is dropped, so its URLs aren't used anymore.
+func (p *Plugin) recordDiscoveryFailure(wopiAddress string, err error) {
+	if p.discovery.Get().WOPIAddress != wopiAddress {
+		p.discovery.Swap(&Discovery{WOPIAddress: wopiAddress, Files: map[string]WopiApp{}, MimeTypes: map[string]WopiApp{}})
+	}
+
+	p.updateDiscoveryStatus(func(status *DiscoveryStatus) {
+		status.Error = err.Error()
+		status.Attempts++
+		status.LastAttemptAt = model.GetMillis()
+	})
+}
+
+// runDiscoveryJob keeps the discovery up to date on all the servers of the cluster.
+// It stops when the stop channel is closed.
+func (p *Plugin) runDiscoveryJob(stop <-chan struct{}) {
+	ticker := time.NewTicker(discoverySyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := p.syncDiscovery(); err != nil {
+				p.API.LogError("Failed to update the WOPI discovery.", "Error", err.Error())
+			}
+		}
+	}
+}
+
+// syncDiscovery loads the discovery refreshed by another server of the cluster,
+// and refreshes it from the Collabora Online server once the refresh interval has passed.
+// The plugin API of this Mattermost version has no cluster events, so the servers share the discovery through the KV store.
+func (p *Plugin) syncDiscovery() error {
+	config := p.getConfiguration()
+	if config.WOPIAddress == "" {
+		return nil
+	}
+
+	stored, err := p.getStoredDiscovery()
+	if err != nil {
+		return err
+	}
+
+	current := p.discovery.Get()
+	if stored != nil && stored.WOPIAddress == config.WOPIAddress && stored.FetchedAt > current.FetchedAt {
+		discovery, err := parseDiscovery(stored)
+		if err != nil {
+			return err
+		}
+
+		// another server of the cluster reached Collabora Online
+		p.swapDiscovery(discovery)
+		p.resetDiscoveryStatus()
+		p.stopDiscoveryRetry()
+		current = discovery
+	}
+
+	if config.discoveryRefreshInterval == 0 {
+		return nil
+	}
+	if current.WOPIAddress == config.WOPIAddress && current.FetchedAt+config.discoveryRefreshInterval.Milliseconds() > model.GetMillis() {
+		return nil
+	}
+
+	// make sure only one server of the cluster refreshes the discovery, the others load it from the KV store
+	locked, appErr := p.API.KVSetWithOptions(discoveryRefreshLockKey, []byte("locked"), model.PluginKVSetOptions{
+		Atomic:          true,
+		ExpireInSeconds: int64(discoverySyncInterval / time.Second),
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to acquire the discovery refresh lock")
+	}
+
+	if !locked {
+		return nil
+	}
+
+	if _, err = p.refreshDiscovery(config.WOPIAddress); err != nil {
+		p.continueDiscoveryRetry(config.WOPIAddress)
+		return err
+	}
+	p.stopDiscoveryRetry()
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// discoveryRetryMinDelay and discoveryRetryMaxDelay bound the delay between the attempts to load
+	// the discovery while Collabora Online is unreachable, doubled after every failed attempt
+	discoveryRetryMinDelay = 10 * time.Second
+	discoveryRetryMaxDelay = 5 * time.Minute
+
+	// WebsocketEventDiscoveryUpdated is sent to all the users when the discovery changes, so the clients reload the supported files
+	WebsocketEventDiscoveryUpdated = "discovery_updated"
+)
+
+// DiscoveryStatus tells whether the discovery of the Collabora Online server could be loaded.
+// Without a discovery the plugin runs in degraded mode: the files can't be opened until Collabora Online is reachable again.
+// While Collabora Online can't be reached the last discovery fetched may be used, the status is then degraded.
+type DiscoveryStatus struct {
+	Available     bool   `json:"available"`
+	Degraded      bool   `json:"degraded"`
+	Error         string `json:"error,omitempty"`
+	Attempts      int    `json:"attempts,omitempty"`
+	LastAttemptAt int64  `json:"last_attempt_at,omitempty"`
+	NextRetryAt   int64  `json:"next_retry_at,omitempty"`
+	FetchedAt     int64  `json:"fetched_at,omitempty"`
+}
+
+// isLoaded checks if the discovery was fetched from the Collabora Online server
+func (d *Discovery) isLoaded() bool {
+	return d.FetchedAt != 0
+}
+
+// getDiscoveryStatus returns the status of the discovery
+func (p *Plugin) getDiscoveryStatus() *DiscoveryStatus {
+	p.discoveryStatusLock.RLock()
+	defer p.discoveryStatusLock.RUnlock()
+
+	status := p.discoveryStatus
+	discovery := p.discovery.Get()
+	status.Available = discovery.isLoaded()
+	status.Degraded = status.Error != ""
+	status.FetchedAt = discovery.FetchedAt
+	return &status
+}
+
+// resetDiscoveryStatus clears the failures, once the discovery was fetched from the Collabora Online server
+func (p *Plugin) resetDiscoveryStatus() {
+	p.updateDiscoveryStatus(func(status *DiscoveryStatus) {
+		*status = DiscoveryStatus{}
+	})
+}
+
+// updateDiscoveryStatus applies update to the status of the discovery
+func (p *Plugin) updateDiscoveryStatus(update func(status *DiscoveryStatus)) {
+	p.discoveryStatusLock.Lock()
+	defer p.discoveryStatusLock.Unlock()
+
+	update(&p.discoveryStatus)
+}
+
+// startDiscoveryRetry retries loading the discovery with an exponential backoff, until it succeeds,
+// the Collabora Online URL changes or the plugin is deactivated
+func (p *Plugin) startDiscoveryRetry(wopiAddress string) {
+	p.stopDiscoveryRetry()
+	p.continueDiscoveryRetry(wopiAddress)
+}
+
+// continueDiscoveryRetry starts retrying to load the discovery, unless a retry is already running
+func (p *Plugin) continueDiscoveryRetry(wopiAddress string) {
+	p.discoveryStatusLock.Lock()
+	defer p.discoveryStatusLock.Unlock()
+
+	if p.stopDiscoveryRetryChan != nil {
+		return
+	}
+	stop := make(chan struct{})
+	p.stopDiscoveryRetryChan = stop
+	p.discoveryStatus.NextRetryAt = model.GetMillisForTime(time.Now().Add(discoveryRetryMinDelay))
+
+	go p.retryDiscovery(wopiAddress, stop)
+}
+
+// stopDiscoveryRetry stops retrying to load the discovery
+func (p *Plugin) stopDiscoveryRetry() {
+	p.discoveryStatusLock.Lock()
+	defer p.discoveryStatusLock.Unlock()
+
+	if p.stopDiscoveryRetryChan != nil {
+		close(p.stopDiscoveryRetryChan)
+		p.stopDiscoveryRetryChan = nil
+	}
+	p.discoveryStatus.NextRetryAt = 0
+}
+
+// finishDiscoveryRetry forgets the retry once it succeeded, unless another retry was started since
+func (p *Plugin) finishDiscoveryRetry(stop <-chan struct{}) {
+	p.discoveryStatusLock.Lock()
+	defer p.discoveryStatusLock.Unlock()
+
+	if p.stopDiscoveryRetryChan != nil && (<-chan struct{})(p.stopDiscoveryRetryChan) == stop {
+		close(p.stopDiscoveryRetryChan)
+		p.stopDiscoveryRetryChan = nil
+		p.discoveryStatus.NextRetryAt = 0
+	}
+}
+
+func (p *Plugin) retryDiscovery(wopiAddress string, stop <-chan struct{}) {
+	delay := discoveryRetryMinDelay
+	for {
+		p.updateDiscoveryStatus(func(status *DiscoveryStatus) {
+			status.NextRetryAt = model.GetMillisForTime(time.Now().Add(delay))
+		})
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		// another server of the cluster may have fetched the discovery in the meantime
+		if discovery := p.discovery.Get(); discovery.isLoaded() && discovery.WOPIAddress == wopiAddress && !p.getDiscoveryStatus().Degraded {
+			p.finishDiscoveryRetry(stop)
+			return
+		}
+		if p.getConfiguration().WOPIAddress != wopiAddress {
+			return
+		}
+
+		if err := p.LoadWopiFileInfo(wopiAddress); err == nil {
+			p.API.LogInfo("Collabora Online is reachable again, the files can be opened.")
+			p.finishDiscoveryRetry(stop)
+			return
+		}
+
+		if delay *= 2; delay > discoveryRetryMaxDelay {
+			delay = discoveryRetryMaxDelay
+		}
+	}
+}
+
+// publishDiscoveryUpdatedEvent tells the clients to reload the supported files
+func (p *Plugin) publishDiscoveryUpdatedEvent(discovery *Discovery) {
+	p.API.PublishWebSocketEvent(WebsocketEventDiscoveryUpdated, map[string]interface{}{
+		"fetched_at": discovery.FetchedAt,
+	}, &model.WebsocketBroadcast{})
+}
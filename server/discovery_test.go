@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const testDiscoveryXML = `<wopi-discovery><net-zone name="external-http">
+<app name="writer"><action default="true" ext="docx" name="edit" urlsrc="http://collabora/browser/cool.html?"/></app>
+</net-zone></wopi-discovery>`
+
+func TestDiscoveryUnreachable(t *testing.T) {
+	var reachable int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&reachable) == 0 || r.URL.Path != "/hosting/discovery" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(testDiscoveryXML))
+	}))
+	defer server.Close()
+
+	api := newTestAPI()
+	p := newTestPlugin(api)
+	p.setConfiguration(&configuration{WOPIAddress: server.URL, discoveryRefreshInterval: time.Hour})
+	defer p.stopDiscoveryRetry()
+
+	// a discovery was fetched from the same server before it became unreachable
+	fetchedAt := model.GetMillis() - 2*time.Hour.Milliseconds()
+	api.kv[discoveryKey], _ = json.Marshal(&storedDiscovery{WOPIAddress: server.URL, XML: []byte(testDiscoveryXML), FetchedAt: fetchedAt})
+
+	if err := p.LoadWopiFileInfo(server.URL); err == nil {
+		t.Fatal("expected an error while Collabora Online is unreachable")
+	}
+
+	discovery := p.discovery.Get()
+	if discovery.FetchedAt != fetchedAt {
+		t.Errorf("expected the stored discovery to be used, fetched at %d, got %d", fetchedAt, discovery.FetchedAt)
+	}
+	if _, ok := discovery.Files["docx"]; !ok {
+		t.Errorf("expected the files of the stored discovery, got %v", discovery.Files)
+	}
+
+	status := p.getDiscoveryStatus()
+	if !status.Available || !status.Degraded || status.Error == "" || status.Attempts != 1 {
+		t.Errorf("expected an available but degraded discovery with the error, got %+v", status)
+	}
+
+	// the refresh job keeps the failure and schedules a retry
+	if err := p.syncDiscovery(); err == nil {
+		t.Fatal("expected the refresh to fail while Collabora Online is unreachable")
+	}
+	status = p.getDiscoveryStatus()
+	if !status.Degraded || status.Attempts != 2 || status.NextRetryAt == 0 {
+		t.Errorf("expected a degraded discovery with a retry scheduled, got %+v", status)
+	}
+
+	// the discovery is fetched again once Collabora Online is reachable
+	atomic.StoreInt32(&reachable, 1)
+	delete(api.kv, discoveryRefreshLockKey)
+	if err := p.syncDiscovery(); err != nil {
+		t.Fatalf("failed to refresh the discovery: %v", err)
+	}
+	status = p.getDiscoveryStatus()
+	if !status.Available || status.Degraded || status.Error != "" || status.NextRetryAt != 0 {
+		t.Errorf("expected the discovery status to be reset, got %+v", status)
+	}
+	if p.discovery.Get().FetchedAt <= fetchedAt {
+		t.Error("expected the discovery to be fetched from Collabora Online")
+	}
+}
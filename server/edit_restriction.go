@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EditRestrictionReadOnly prevents everyone from editing the file
+	EditRestrictionReadOnly = "read_only"
+	// EditRestrictionUsers only allows the named users to edit the file
+	EditRestrictionUsers = "users"
+
+	// editRestrictionKeyPrefix is the KV store key prefix used for the edit restriction of a file
+	editRestrictionKeyPrefix = "file_edit_restriction_"
+
+	// PropEditRestrictions is the post prop listing the restricted files of the post, mapping the file ID to the restriction description
+	PropEditRestrictions = "collabora_edit_restrictions"
+
+	// editRestrictionAttachmentID identifies the attachments added to a post to show the restricted files
+	editRestrictionAttachmentID = 7467
+)
+
+// FileEditRestriction is set by the owner of a file, or a channel admin, to freeze it
+type FileEditRestriction struct {
+	Mode    string   `json:"mode"`
+	UserIDs []string `json:"userIds,omitempty"`
+	SetBy   string   `json:"setBy"`
+	SetAt   int64    `json:"setAt"`
+}
+
+func getEditRestrictionKey(fileID string) string {
+	return editRestrictionKeyPrefix + fileID
+}
+
+// allows checks if the restriction allows the user to edit the file
+func (r *FileEditRestriction) allows(userID string) bool {
+	if r.Mode != EditRestrictionUsers {
+		return false
+	}
+
+	for _, id := range r.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// getFileEditRestriction returns the edit restriction of the file, or nil if the file isn't restricted
+func (p *Plugin) getFileEditRestriction(fileID string) (*FileEditRestriction, error) {
+	data, appErr := p.API.KVGet(getEditRestrictionKey(fileID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the file edit restriction from KV store")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	restriction := &FileEditRestriction{}
+	if err := json.Unmarshal(data, restriction); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the file edit restriction")
+	}
+	return restriction, nil
+}
+
+// setFileEditRestriction restricts the editing of the file. A nil restriction makes the file editable again.
+func (p *Plugin) setFileEditRestriction(fileID string, restriction *FileEditRestriction) error {
+	key := getEditRestrictionKey(fileID)
+	if restriction == nil {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			return errors.Wrap(appErr, "failed to delete the file edit restriction from KV store")
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(restriction)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the file edit restriction")
+	}
+
+	if appErr := p.API.KVSet(key, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the file edit restriction in KV store")
+	}
+	return nil
+}
+
+// canManageEditRestriction checks if the user can restrict the editing of the files of the post:
+// the user who posted them or a channel admin
+func (p *Plugin) canManageEditRestriction(userID string, post *model.Post) bool {
+	return post.UserId == userID || p.isChannelAdmin(userID, post.ChannelId)
+}
+
+// describeEditRestriction describes the restriction, as shown in the post of the file
+func (p *Plugin) describeEditRestriction(fileName string, restriction *FileEditRestriction) string {
+	if restriction.Mode == EditRestrictionReadOnly {
+		return ":lock: **" + fileName + "** is read-only."
+	}
+
+	usernames := make([]string, 0, len(restriction.UserIDs))
+	for _, userID := range restriction.UserIDs {
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			usernames = append(usernames, "@"+user.Username)
+		}
+	}
+	return ":lock: **" + fileName + "** can only be edited by " + strings.Join(usernames, ", ") + "."
+}
+
+// updatePostEditRestrictions shows the edit restriction of the file in its post
+func (p *Plugin) updatePostEditRestrictions(post *model.Post, fileInfo *model.FileInfo, restriction *FileEditRestriction) error {
+	description := ""
+	if restriction != nil {
+		description = p.describeEditRestriction(fileInfo.Name, restriction)
+	}
+	return p.setPostFileDescription(post, PropEditRestrictions, editRestrictionAttachmentID, fileInfo.Id, description)
+}
+
+// applyEditRestriction opens the file in view-only mode for the users the edit restriction set on the file doesn't allow to change it
+func (p *Plugin) applyEditRestriction(access *FileAccess) {
+	if !access.CanComment {
+		return
+	}
+
+	restriction, err := p.getFileEditRestriction(access.FileInfo.Id)
+	if err != nil {
+		// don't let a storage error unlock a file meant to stay final
+		p.API.LogError("Failed to get the file edit restriction.", "FileID", access.FileInfo.Id, "Error", err.Error())
+		restriction = &FileEditRestriction{Mode: EditRestrictionReadOnly}
+	}
+
+	if restriction == nil || restriction.allows(access.User.Id) {
+		return
+	}
+
+	if restriction.Mode == EditRestrictionReadOnly {
+		access.restrict("edit restriction", "the file is read-only")
+		access.Notice = "This file was made read-only."
+	} else {
+		access.restrict("edit restriction", "the user isn't allowed to edit the file")
+		access.Notice = "Only some users are allowed to edit this file."
+	}
+	access.CanComment = false
+	access.CanEdit = false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestApplyEditRestriction(t *testing.T) {
+	member := []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE}
+	editor := accessFlags{view: true, comment: true, edit: true, export: true}
+	viewer := accessFlags{view: true, export: true}
+
+	tests := []struct {
+		name string
+
+		// restriction is the restriction of the file, the user being allowed if allowed is set
+		restriction *FileEditRestriction
+		allowed     bool
+
+		expected accessFlags
+	}{
+		{"no restriction", nil, false, editor},
+		{"read-only", &FileEditRestriction{Mode: EditRestrictionReadOnly}, false, viewer},
+		{"read-only ignores the users", &FileEditRestriction{Mode: EditRestrictionReadOnly}, true, viewer},
+		{"allowed user", &FileEditRestriction{Mode: EditRestrictionUsers}, true, editor},
+		{"other user", &FileEditRestriction{Mode: EditRestrictionUsers}, false, viewer},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			p.setConfiguration(&configuration{EditingPolicy: EditingPolicyAll})
+			user := api.addUser(model.SYSTEM_USER_ROLE_ID)
+			channel, post, fileInfo := api.addFile(model.NewId())
+			api.grant(user.Id, channel.Id, member...)
+
+			if test.restriction != nil {
+				test.restriction.UserIDs = []string{model.NewId()}
+				if test.allowed {
+					test.restriction.UserIDs = append(test.restriction.UserIDs, user.Id)
+				}
+				if err := p.setFileEditRestriction(fileInfo.Id, test.restriction); err != nil {
+					t.Fatalf("failed to restrict the file: %v", err)
+				}
+			}
+
+			access, err := p.getFileAccess(user.Id, fileInfo, post)
+			if err != nil {
+				t.Fatalf("failed to get the file access: %v", err)
+			}
+			if flags := flagsOf(access); flags != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, flags)
+			}
+		})
+	}
+}
+
+func TestUpdatePostEditRestrictions(t *testing.T) {
+	api := newTestAPI()
+	p := newTestPlugin(api)
+	author := api.addUser(model.SYSTEM_USER_ROLE_ID)
+	_, post, fileInfo := api.addFile(author.Id)
+	post.AddProp("attachments", []*model.SlackAttachment{{Text: "kept"}})
+
+	restriction := &FileEditRestriction{Mode: EditRestrictionReadOnly}
+	if err := p.updatePostEditRestrictions(post, fileInfo, restriction); err != nil {
+		t.Fatalf("failed to show the restriction: %v", err)
+	}
+	updated, _ := api.GetPost(post.Id)
+	var texts []string
+	for _, attachment := range updated.Attachments() {
+		texts = append(texts, attachment.Text)
+	}
+	if len(texts) != 2 || texts[0] != "kept" || !strings.Contains(texts[1], "is read-only") {
+		t.Fatalf("expected the restriction to be shown after the other attachments, got %v", texts)
+	}
+
+	if err := p.updatePostEditRestrictions(updated, fileInfo, nil); err != nil {
+		t.Fatalf("failed to remove the restriction: %v", err)
+	}
+	updated, _ = api.GetPost(post.Id)
+	if attachments := updated.Attachments(); len(attachments) != 1 || attachments[0].Text != "kept" {
+		t.Errorf("expected only the other attachments to be kept, got %+v", attachments)
+	}
+}
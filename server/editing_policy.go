@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EditingPolicyAll allows all the channel members to edit the files
+	EditingPolicyAll = "all"
+	// EditingPolicyOwner only allows the user who posted a file to edit it
+	EditingPolicyOwner = "owner"
+	// EditingPolicyChannelAdmin only allows the channel admins to edit the files
+	EditingPolicyChannelAdmin = "channel_admin"
+
+	// channelEditingPolicyKeyPrefix is the KV store key prefix used for the editing policy of a channel
+	channelEditingPolicyKeyPrefix = "channel_editing_policy_"
+)
+
+func isValidEditingPolicy(policy string) bool {
+	return policy == EditingPolicyAll || policy == EditingPolicyOwner || policy == EditingPolicyChannelAdmin
+}
+
+func getChannelEditingPolicyKey(channelID string) string {
+	return channelEditingPolicyKeyPrefix + channelID
+}
+
+// getChannelEditingPolicyOverride returns the editing policy set for the channel, or an empty string if the channel uses the default policy
+func (p *Plugin) getChannelEditingPolicyOverride(channelID string) (string, error) {
+	data, appErr := p.API.KVGet(getChannelEditingPolicyKey(channelID))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get the channel editing policy from KV store")
+	}
+	return string(data), nil
+}
+
+// getChannelEditingPolicy returns the editing policy of the channel
+func (p *Plugin) getChannelEditingPolicy(channelID string) (string, error) {
+	policy, err := p.getChannelEditingPolicyOverride(channelID)
+	if err != nil {
+		return "", err
+	}
+
+	if policy == "" {
+		return p.getConfiguration().EditingPolicy, nil
+	}
+	return policy, nil
+}
+
+// setChannelEditingPolicy overrides the editing policy of the channel. An empty policy restores the default policy.
+func (p *Plugin) setChannelEditingPolicy(channelID, policy string) error {
+	key := getChannelEditingPolicyKey(channelID)
+	if policy == "" {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			return errors.Wrap(appErr, "failed to delete the channel editing policy from KV store")
+		}
+		return nil
+	}
+
+	if !isValidEditingPolicy(policy) {
+		return errors.New("invalid editing policy: " + policy)
+	}
+
+	if appErr := p.API.KVSet(key, []byte(policy)); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the channel editing policy in KV store")
+	}
+	return nil
+}
+
+// isChannelAdmin checks if the user administers the channel, as a channel, team or system admin
+func (p *Plugin) isChannelAdmin(userID, channelID string) bool {
+	return p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_MANAGE_CHANNEL_ROLES)
+}
+
+// applyEditingPolicy opens the file in view-only mode for the users the editing policy of the channel doesn't allow to change it
+func (p *Plugin) applyEditingPolicy(access *FileAccess) {
+	if !access.CanComment {
+		return
+	}
+
+	policy, err := p.getChannelEditingPolicy(access.Channel.Id)
+	if err != nil {
+		p.API.LogError("Failed to get the channel editing policy. Falling back to the default policy.", "ChannelID", access.Channel.Id, "Error", err.Error())
+		policy = p.getConfiguration().EditingPolicy
+	}
+
+	switch policy {
+	case EditingPolicyOwner:
+		if access.Post.UserId == access.User.Id {
+			return
+		}
+		access.restrict("editing policy", "only the owner of the file can change it")
+		access.Notice = "Only the user who posted this file can edit it."
+	case EditingPolicyChannelAdmin:
+		if p.isChannelAdmin(access.User.Id, access.Channel.Id) {
+			return
+		}
+		access.restrict("editing policy", "only the channel admins can change the file")
+		access.Notice = "Only the channel admins can edit the files of this channel."
+	default:
+		return
+	}
+
+	access.CanComment = false
+	access.CanEdit = false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestApplyEditingPolicy(t *testing.T) {
+	member := []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE}
+	admin := append([]*model.Permission{model.PERMISSION_MANAGE_CHANNEL_ROLES}, member...)
+	editor := accessFlags{view: true, comment: true, edit: true, export: true}
+	viewer := accessFlags{view: true, export: true}
+
+	tests := []struct {
+		name string
+
+		defaultPolicy string
+		channelPolicy string
+
+		// owner tells if the user posted the file
+		owner       bool
+		permissions []*model.Permission
+
+		expected accessFlags
+	}{
+		{"all: member", EditingPolicyAll, "", false, member, editor},
+		{"owner: owner", EditingPolicyOwner, "", true, member, editor},
+		{"owner: member", EditingPolicyOwner, "", false, member, viewer},
+		{"owner: channel admin", EditingPolicyOwner, "", false, admin, viewer},
+		{"channel admin: channel admin", EditingPolicyChannelAdmin, "", false, admin, editor},
+		{"channel admin: owner", EditingPolicyChannelAdmin, "", true, member, viewer},
+		{"channel override to owner: member", EditingPolicyAll, EditingPolicyOwner, false, member, viewer},
+		{"channel override to all: member", EditingPolicyChannelAdmin, EditingPolicyAll, false, member, editor},
+		{"owner: owner who can only read", EditingPolicyOwner, "", true, []*model.Permission{model.PERMISSION_READ_CHANNEL}, accessFlags{view: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			p.setConfiguration(&configuration{EditingPolicy: test.defaultPolicy})
+			user := api.addUser("system_user")
+
+			authorID := model.NewId()
+			if test.owner {
+				authorID = user.Id
+			}
+			channel, post, fileInfo := api.addFile(authorID)
+			api.grant(user.Id, channel.Id, test.permissions...)
+			if err := p.setChannelEditingPolicy(channel.Id, test.channelPolicy); err != nil {
+				t.Fatalf("failed to set the channel editing policy: %v", err)
+			}
+
+			access, err := p.getFileAccess(user.Id, fileInfo, post)
+			if err != nil {
+				t.Fatalf("failed to get the file access: %v", err)
+			}
+			if flags := flagsOf(access); flags != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// fileMetadataKeyPrefix is the KV store key prefix used for the file metadata
+	fileMetadataKeyPrefix = "file_metadata_"
+
+	// PropRenamedFiles is the post prop listing the files renamed in Collabora Online, mapping the file ID to the description of the rename
+	PropRenamedFiles = "collabora_renamed_files"
+
+	// renamedFileAttachmentID identifies the attachments added to a post to show the new names of its files
+	renamedFileAttachmentID = 7469
+
+	// wopiTimestampLayout is the ISO 8601 layout used for the WOPI LastModifiedTime
+	wopiTimestampLayout = "2006-01-02T15:04:05.000Z"
+)
+
+// FileMetadata contains the file information changed through Collabora Online.
+// The plugin API doesn't allow updating Mattermost FileInfo records, so the plugin keeps
+// these changes in the KV store and applies them on top of the FileInfo when reading it.
+type FileMetadata struct {
+	// Name is the file name set by RenameFile, including the extension
+	Name string `json:"name,omitempty"`
+
+	// Version is incremented every time the file contents change
+	Version int64 `json:"version"`
+
+	// ModifiedBy is the ID of the user who last changed the file contents
+	ModifiedBy string `json:"modifiedBy,omitempty"`
+
+	// ModifiedAt is the time of the last change of the file contents
+	ModifiedAt int64 `json:"modifiedAt,omitempty"`
+
+	// IsAutosave is set if the last change was saved automatically by Collabora Online
+	IsAutosave bool `json:"isAutosave,omitempty"`
+
+	// Size is the size of the file contents in bytes after the last change
+	Size int64 `json:"size,omitempty"`
+
+	// MimeType is the MIME type detected after the last change, if Mattermost didn't detect one
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+func getFileMetadataKey(fileID string) string {
+	return fileMetadataKeyPrefix + fileID
+}
+
+// getFileMetadata returns the metadata stored for the file, or nil if there is none
+func (p *Plugin) getFileMetadata(fileID string) (*FileMetadata, error) {
+	data, appErr := p.API.KVGet(getFileMetadataKey(fileID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get file metadata from KV store")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	var metadata FileMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal file metadata")
+	}
+	return &metadata, nil
+}
+
+// updateFileMetadata atomically applies update to the metadata stored for the file and returns the updated metadata
+func (p *Plugin) updateFileMetadata(fileID string, update func(metadata *FileMetadata)) (*FileMetadata, error) {
+	metadata := &FileMetadata{}
+	err := p.kvAtomicUpdate(getFileMetadataKey(fileID), func(data []byte) ([]byte, error) {
+		metadata = &FileMetadata{}
+		if data != nil {
+			if err := json.Unmarshal(data, metadata); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal file metadata")
+			}
+		}
+
+		update(metadata)
+		return json.Marshal(metadata)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save file metadata")
+	}
+	return metadata, nil
+}
+
+// getOrCreateFileMetadata returns the metadata stored for the file, or empty metadata if there is none
+func (p *Plugin) getOrCreateFileMetadata(fileID string) (*FileMetadata, error) {
+	metadata, err := p.getFileMetadata(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		metadata = &FileMetadata{}
+	}
+	return metadata, nil
+}
+
+// getFileVersion returns the version of the file contents, used as the WOPI file version
+func (p *Plugin) getFileVersion(fileID string) string {
+	metadata, err := p.getFileMetadata(fileID)
+	if err != nil || metadata == nil {
+		return "0"
+	}
+	return strconv.FormatInt(metadata.Version, 10)
+}
+
+// getFileInfo returns the Mattermost FileInfo with the file metadata maintained by the plugin applied
+func (p *Plugin) getFileInfo(fileID string) (*model.FileInfo, error) {
+	fileInfo, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	metadata, err := p.getFileMetadata(fileID)
+	if err != nil {
+		p.API.LogWarn("Failed to get file metadata.", "FileID", fileID, "Error", err.Error())
+		return fileInfo, nil
+	}
+
+	if metadata != nil {
+		metadata.applyTo(fileInfo)
+	}
+	return fileInfo, nil
+}
+
+// applyTo overrides the FileInfo fields with the metadata
+func (m *FileMetadata) applyTo(fileInfo *model.FileInfo) {
+	if m.Name != "" {
+		fileInfo.Name = m.Name
+	}
+
+	// the metadata is only more recent than the FileInfo if the contents were changed through the plugin
+	if m.ModifiedAt > fileInfo.UpdateAt {
+		fileInfo.UpdateAt = m.ModifiedAt
+		fileInfo.Size = m.Size
+	}
+
+	if fileInfo.MimeType == "" {
+		fileInfo.MimeType = m.MimeType
+	}
+}
+
+// updatePostFileName shows the name the file was renamed to in its post, as the Mattermost FileInfo keeps the original name
+func (p *Plugin) updatePostFileName(post *model.Post, fileID, name string) error {
+	fileInfo, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the file info")
+	}
+
+	description := ""
+	if name != fileInfo.Name {
+		description = ":pencil: **" + fileInfo.Name + "** was renamed to **" + name + "** in Collabora Online."
+	}
+
+	// get the post again, it may have changed while the file was renamed
+	post, appErr = p.API.GetPost(post.Id)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the post of the file")
+	}
+	return p.setPostFileDescription(post, PropRenamedFiles, renamedFileAttachmentID, fileID, description)
+}
+
+// formatWopiTimestamp formats a Mattermost timestamp (milliseconds) as a WOPI LastModifiedTime
+func formatWopiTimestamp(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format(wopiTimestampLayout)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestRenamedFile checks how a file renamed in Collabora Online is shown: the plugin API can't update the FileInfo,
+// so the new name is only applied by the plugin and shown in the post, while Mattermost keeps the original name.
+func TestRenamedFile(t *testing.T) {
+	api := newTestAPI()
+	p := newTestPlugin(api)
+	author := api.addUser("system_user")
+	_, post, fileInfo := api.addFile(author.Id)
+
+	api.kv[getFileMetadataKey(fileInfo.Id)], _ = json.Marshal(&FileMetadata{Name: "summary.docx"})
+
+	renamed, err := p.getFileInfo(fileInfo.Id)
+	if err != nil {
+		t.Fatalf("failed to get the file info: %v", err)
+	}
+	if renamed.Name != "summary.docx" {
+		t.Errorf("expected the plugin to use the new name, got %q", renamed.Name)
+	}
+	if original, _ := api.GetFileInfo(fileInfo.Id); original.Name != "report.docx" {
+		t.Errorf("expected Mattermost to keep the original name, got %q", original.Name)
+	}
+
+	if err := p.updatePostFileName(post, fileInfo.Id, "summary.docx"); err != nil {
+		t.Fatalf("failed to show the new name in the post: %v", err)
+	}
+	updated, _ := api.GetPost(post.Id)
+	attachments := updated.Attachments()
+	if len(attachments) != 1 || !strings.Contains(attachments[0].Text, "**report.docx** was renamed to **summary.docx**") {
+		t.Fatalf("expected the post to show the new name, got %+v", attachments)
+	}
+
+	// renaming the file back to its original name removes the description
+	if err := p.updatePostFileName(post, fileInfo.Id, "report.docx"); err != nil {
+		t.Fatalf("failed to show the original name in the post: %v", err)
+	}
+	updated, _ = api.GetPost(post.Id)
+	if len(updated.Attachments()) != 0 || updated.GetProp(PropRenamedFiles) != nil {
+		t.Errorf("expected the description of the rename to be removed, got %+v", updated.Attachments())
+	}
+}
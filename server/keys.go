@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptionKeysKey is the KV store key of the encryption key ring
+	encryptionKeysKey = "wopi_encryption_keys"
+
+	// keyRotationLockKey is the KV store key used to make sure only one server rotates the key
+	keyRotationLockKey = "wopi_key_rotation_lock"
+
+	// keyRotationCheckInterval is how often the servers check if the encryption key must be rotated
+	keyRotationCheckInterval = time.Hour
+
+	// encryptionKeyLength is the length of the generated encryption keys, as generated by the system console
+	encryptionKeyLength = 32
+)
+
+// RetiredEncryptionKey is a previous encryption key, still accepted to verify tokens during the grace period
+type RetiredEncryptionKey struct {
+	Key       string `json:"key"`
+	RetiredAt int64  `json:"retiredAt"`
+}
+
+// EncryptionKeyRing keeps track of the current and previous encryption keys
+type EncryptionKeyRing struct {
+	CurrentKey string                  `json:"currentKey"`
+	RotatedAt  int64                   `json:"rotatedAt"` // the time the current key was introduced
+	Previous   []*RetiredEncryptionKey `json:"previous"`
+}
+
+// getKeyID returns the ID of an encryption key, sent in the kid header of the tokens.
+// It is derived from the key so that all the servers agree on it without sharing state.
+func getKeyID(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:8])
+}
+
+// getKeyRing returns the encryption key ring stored in the KV store
+func (p *Plugin) getKeyRing() (*EncryptionKeyRing, error) {
+	data, appErr := p.API.KVGet(encryptionKeysKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the encryption keys from KV store")
+	}
+
+	keyRing := &EncryptionKeyRing{}
+	if data == nil {
+		return keyRing, nil
+	}
+
+	if err := json.Unmarshal(data, keyRing); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the encryption keys")
+	}
+	return keyRing, nil
+}
+
+// updateKeyRing records the current encryption key, retiring the previous one if it changed,
+// and drops the keys older than the grace period. It returns the keys still valid for verification.
+func (p *Plugin) updateKeyRing(currentKey string, gracePeriod time.Duration) ([]*RetiredEncryptionKey, error) {
+	keyRing := &EncryptionKeyRing{}
+	err := p.kvAtomicUpdate(encryptionKeysKey, func(data []byte) ([]byte, error) {
+		keyRing = &EncryptionKeyRing{}
+		if data != nil {
+			if err := json.Unmarshal(data, keyRing); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal the encryption keys")
+			}
+		}
+
+		now := model.GetMillis()
+		if keyRing.CurrentKey != currentKey {
+			if keyRing.CurrentKey != "" {
+				keyRing.Previous = append([]*RetiredEncryptionKey{{Key: keyRing.CurrentKey, RetiredAt: now}}, keyRing.Previous...)
+			}
+			keyRing.CurrentKey = currentKey
+			keyRing.RotatedAt = now
+		}
+
+		validKeys := make([]*RetiredEncryptionKey, 0, len(keyRing.Previous))
+		for _, key := range keyRing.Previous {
+			if key.Key != currentKey && key.RetiredAt+gracePeriod.Milliseconds() > now {
+				validKeys = append(validKeys, key)
+			}
+		}
+		keyRing.Previous = validKeys
+
+		return json.Marshal(keyRing)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keyRing.Previous, nil
+}
+
+// getVerificationKey returns the key to verify a token signed with the given key ID.
+// Tokens without a key ID were issued before key IDs were introduced and are verified with the current key.
+func (c *configuration) getVerificationKey(keyID string) (string, error) {
+	if keyID == "" || keyID == getKeyID(c.EncryptionKey) {
+		return c.EncryptionKey, nil
+	}
+
+	now := model.GetMillis()
+	for _, key := range c.previousEncryptionKeys {
+		if getKeyID(key.Key) == keyID && key.RetiredAt+c.keyRotationGracePeriod.Milliseconds() > now {
+			return key.Key, nil
+		}
+	}
+
+	return "", errors.New("unknown or expired key ID: " + keyID)
+}
+
+// runKeyRotationJob periodically rotates the encryption key, if scheduled rotation is enabled.
+// It stops when the stop channel is closed.
+func (p *Plugin) runKeyRotationJob(stop <-chan struct{}) {
+	ticker := time.NewTicker(keyRotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := p.rotateEncryptionKeyIfDue(); err != nil {
+				p.API.LogError("Failed to rotate the encryption key.", "Error", err.Error())
+			}
+		}
+	}
+}
+
+// rotateEncryptionKeyIfDue generates a new encryption key if the current one is older than the rotation interval.
+// The previous key remains valid for the grace period, so the open editing sessions are not interrupted.
+func (p *Plugin) rotateEncryptionKeyIfDue() error {
+	config := p.getConfiguration()
+	if config.keyRotationInterval == 0 {
+		return nil
+	}
+
+	keyRing, err := p.getKeyRing()
+	if err != nil {
+		return err
+	}
+
+	if keyRing.CurrentKey != config.EncryptionKey || keyRing.RotatedAt+config.keyRotationInterval.Milliseconds() > model.GetMillis() {
+		return nil
+	}
+
+	// make sure only one server of the cluster rotates the key
+	locked, appErr := p.API.KVSetWithOptions(keyRotationLockKey, []byte("locked"), model.PluginKVSetOptions{
+		Atomic:          true,
+		ExpireInSeconds: int64(keyRotationCheckInterval / time.Second),
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to acquire the key rotation lock")
+	}
+
+	if !locked {
+		return nil
+	}
+
+	pluginConfig := p.API.GetPluginConfig()
+	keySetting := "EncryptionKey"
+	for setting := range pluginConfig {
+		if strings.EqualFold(setting, keySetting) {
+			keySetting = setting
+		}
+	}
+	pluginConfig[keySetting] = model.NewRandomString(encryptionKeyLength)
+
+	// saving the configuration triggers OnConfigurationChange on every server, which retires the previous key
+	if appErr := p.API.SavePluginConfig(pluginConfig); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the new encryption key")
+	}
+
+	p.API.LogInfo("The encryption key was rotated.")
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SaveActionSilent overwrites the file without telling anyone
+	SaveActionSilent = "silent"
+	// SaveActionUpdatePost shows who last edited the file in its post
+	SaveActionUpdatePost = "update_post"
+	// SaveActionNotify shows who last edited the file in its post, and posts a notice in the thread of the file
+	SaveActionNotify = "notify"
+
+	// PropLastEdits is the post prop listing who last edited the files of the post, mapping the file ID to the description of the edit
+	PropLastEdits = "collabora_last_edits"
+
+	// lastEditAttachmentID identifies the attachments added to a post to show who last edited its files
+	lastEditAttachmentID = 7468
+)
+
+func isValidSaveAction(action string) bool {
+	return action == SaveActionSilent || action == SaveActionUpdatePost || action == SaveActionNotify
+}
+
+// getSaveAction returns what to do after the save, following the configuration for its kind.
+// Saves without changes made by the user are always silent.
+func (p *Plugin) getSaveAction(save *FileSave) string {
+	if !save.IsModifiedByUser {
+		return SaveActionSilent
+	}
+
+	config := p.getConfiguration()
+	switch {
+	case save.IsExitSave:
+		return config.ExitSaveAction
+	case save.IsAutosave:
+		return config.AutosaveAction
+	default:
+		return config.ManualSaveAction
+	}
+}
+
+// recordFileEdit records the save in the post of the file and its thread, following the configuration
+func (p *Plugin) recordFileEdit(save *FileSave) error {
+	action := p.getSaveAction(save)
+	p.API.LogInfo("File contents saved.", "FileID", save.FileInfo.Id, "UserID", save.UserID, "Version", save.Metadata.Version,
+		"IsModifiedByUser", save.IsModifiedByUser, "IsAutosave", save.IsAutosave, "IsExitSave", save.IsExitSave, "Action", action)
+
+	if action == SaveActionSilent {
+		return nil
+	}
+
+	username := p.getUsernames([]string{save.UserID})
+	if err := p.updatePostLastEdits(save.Post.Id, save.FileInfo, ":pencil2: **"+save.FileInfo.Name+"** was last edited by "+username+"."); err != nil {
+		return err
+	}
+
+	if action != SaveActionNotify {
+		return nil
+	}
+
+	message := ":pencil2: " + username + " saved changes to **" + save.FileInfo.Name + "**."
+	if save.IsExitSave {
+		message = ":pencil2: " + username + " finished editing **" + save.FileInfo.Name + "**."
+	}
+	return p.replyInThread(save.Post, save.UserID, message)
+}
+
+// updatePostLastEdits shows who last edited the file in its post
+func (p *Plugin) updatePostLastEdits(postID string, fileInfo *model.FileInfo, description string) error {
+	// get the post again, it may have changed while the file was saved
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the post of the file")
+	}
+	return p.setPostFileDescription(post, PropLastEdits, lastEditAttachmentID, fileInfo.Id, description)
+}
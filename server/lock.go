@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lockKeyPrefix is the KV store key prefix used for WOPI locks
+	lockKeyPrefix = "wopi_lock_"
+
+	// WopiLockExpiry is the lifetime of a WOPI lock, as required by the WOPI specification
+	WopiLockExpiry = 30 * time.Minute
+
+	// wopiLockIDMaxLength is the maximum length of a lock ID, as required by the WOPI specification
+	wopiLockIDMaxLength = 1024
+)
+
+// errLockMismatch is returned when the lock ID provided by Collabora Online doesn't match the current lock
+var errLockMismatch = errors.New("lock mismatch")
+
+// WopiLock is a WOPI lock on a file, as stored in the KV store
+type WopiLock struct {
+	LockID    string `json:"lockId"`
+	UserID    string `json:"userId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// isExpired checks if the lock has expired but not yet been purged from the KV store
+func (l *WopiLock) isExpired() bool {
+	return l.ExpiresAt < model.GetMillis()
+}
+
+// WopiLockManager stores WOPI locks in the plugin KV store.
+// All the modifications use compare-and-set operations, so concurrent requests from
+// multiple Collabora Online servers can't overwrite each other's locks.
+type WopiLockManager struct {
+	api plugin.API
+}
+
+// NewWopiLockManager creates a new WopiLockManager
+func NewWopiLockManager(api plugin.API) *WopiLockManager {
+	return &WopiLockManager{api: api}
+}
+
+func getLockKey(fileID string) string {
+	return lockKeyPrefix + fileID
+}
+
+// getLock returns the current lock on the file and the raw value stored in the KV store.
+// The returned lock is nil if the file is not locked.
+func (m *WopiLockManager) getLock(fileID string) (*WopiLock, []byte, error) {
+	data, appErr := m.api.KVGet(getLockKey(fileID))
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to get lock from KV store")
+	}
+
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	var lock WopiLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, data, errors.Wrap(err, "failed to unmarshal lock")
+	}
+
+	if lock.isExpired() {
+		return nil, data, nil
+	}
+
+	return &lock, data, nil
+}
+
+// compareAndSetLock replaces the stored lock with newLock only if the stored value is still oldData.
+// If newLock is nil, the lock is removed.
+func (m *WopiLockManager) compareAndSetLock(fileID string, oldData []byte, newLock *WopiLock) (bool, error) {
+	key := getLockKey(fileID)
+	if newLock == nil {
+		if oldData == nil {
+			return true, nil
+		}
+
+		deleted, appErr := m.api.KVCompareAndDelete(key, oldData)
+		if appErr != nil {
+			return false, errors.Wrap(appErr, "failed to delete lock from KV store")
+		}
+		return deleted, nil
+	}
+
+	newData, err := json.Marshal(newLock)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal lock")
+	}
+
+	saved, appErr := m.api.KVSetWithOptions(key, newData, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        oldData,
+		ExpireInSeconds: int64(WopiLockExpiry / time.Second),
+	})
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to save lock in KV store")
+	}
+	return saved, nil
+}
+
+// currentLockID returns the ID of the current lock on the file, or an empty string if the file is not locked
+func (m *WopiLockManager) currentLockID(fileID string) string {
+	lock, _, err := m.getLock(fileID)
+	if err != nil || lock == nil {
+		return ""
+	}
+	return lock.LockID
+}
+
+// GetLock returns the ID of the current lock on the file, or an empty string if the file is not locked
+func (m *WopiLockManager) GetLock(fileID string) (string, error) {
+	lock, _, err := m.getLock(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if lock == nil {
+		return "", nil
+	}
+	return lock.LockID, nil
+}
+
+// Lock locks the file with the given lock ID, or refreshes the lock if the file is already locked with the same ID.
+// If oldLockID is not empty, the current lock must match oldLockID and is replaced with lockID (UnlockAndRelock).
+// On mismatch errLockMismatch is returned along with the ID of the current lock.
+func (m *WopiLockManager) Lock(fileID, userID, lockID, oldLockID string) (string, error) {
+	lock, data, err := m.getLock(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	currentLockID := ""
+	if lock != nil {
+		currentLockID = lock.LockID
+	}
+
+	if oldLockID != "" {
+		if currentLockID != oldLockID {
+			return currentLockID, errLockMismatch
+		}
+	} else if lock != nil && currentLockID != lockID {
+		return currentLockID, errLockMismatch
+	}
+
+	return m.setLock(fileID, data, &WopiLock{
+		LockID:    lockID,
+		UserID:    userID,
+		ExpiresAt: model.GetMillis() + WopiLockExpiry.Milliseconds(),
+	})
+}
+
+// RefreshLock resets the expiry of the lock on the file.
+// On mismatch errLockMismatch is returned along with the ID of the current lock.
+func (m *WopiLockManager) RefreshLock(fileID, lockID string) (string, error) {
+	lock, data, err := m.getLock(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if lock == nil {
+		return "", errLockMismatch
+	}
+
+	if lock.LockID != lockID {
+		return lock.LockID, errLockMismatch
+	}
+
+	return m.setLock(fileID, data, &WopiLock{
+		LockID:    lock.LockID,
+		UserID:    lock.UserID,
+		ExpiresAt: model.GetMillis() + WopiLockExpiry.Milliseconds(),
+	})
+}
+
+// Unlock removes the lock on the file.
+// On mismatch errLockMismatch is returned along with the ID of the current lock.
+func (m *WopiLockManager) Unlock(fileID, lockID string) (string, error) {
+	lock, data, err := m.getLock(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if lock == nil {
+		return "", errLockMismatch
+	}
+
+	if lock.LockID != lockID {
+		return lock.LockID, errLockMismatch
+	}
+
+	return m.setLock(fileID, data, nil)
+}
+
+// setLock atomically replaces the lock, returning errLockMismatch if the lock was changed concurrently
+func (m *WopiLockManager) setLock(fileID string, oldData []byte, newLock *WopiLock) (string, error) {
+	saved, err := m.compareAndSetLock(fileID, oldData, newLock)
+	if err != nil {
+		return "", err
+	}
+
+	if !saved {
+		return m.currentLockID(fileID), errLockMismatch
+	}
+
+	if newLock == nil {
+		return "", nil
+	}
+	return newLock.LockID, nil
+}
+
+// CanWrite checks if the file can be written by a request holding the given lock ID.
+// An unlocked file can always be written. Otherwise the ID of the current lock is returned.
+func (m *WopiLockManager) CanWrite(fileID, lockID string) (string, error) {
+	currentLockID, err := m.GetLock(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if currentLockID != "" && currentLockID != lockID {
+		return currentLockID, errLockMismatch
+	}
+	return currentLockID, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+func TestWopiLockManager(t *testing.T) {
+	const fileID = "file"
+
+	tests := []struct {
+		name string
+
+		// current is the lock on the file before the operation, nil if the file isn't locked
+		current *WopiLock
+
+		operation func(m *WopiLockManager) (string, error)
+
+		expectedLockID  string
+		expectedErr     error
+		expectedCurrent string
+	}{
+		{
+			name:            "lock an unlocked file",
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "user", "A", "") },
+			expectedLockID:  "A",
+			expectedCurrent: "A",
+		},
+		{
+			name:            "refresh a lock by locking again",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "user", "A", "") },
+			expectedLockID:  "A",
+			expectedCurrent: "A",
+		},
+		{
+			name:            "lock a file locked by another session",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "other", "B", "") },
+			expectedLockID:  "A",
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "A",
+		},
+		{
+			name:            "lock a file whose lock expired",
+			current:         &WopiLock{LockID: "A", UserID: "user", ExpiresAt: 1},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "other", "B", "") },
+			expectedLockID:  "B",
+			expectedCurrent: "B",
+		},
+		{
+			name:            "unlock and relock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "user", "B", "A") },
+			expectedLockID:  "B",
+			expectedCurrent: "B",
+		},
+		{
+			name:            "unlock and relock with the wrong old lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "user", "C", "B") },
+			expectedLockID:  "A",
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "A",
+		},
+		{
+			name:            "unlock and relock an unlocked file",
+			operation:       func(m *WopiLockManager) (string, error) { return m.Lock(fileID, "user", "B", "A") },
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "",
+		},
+		{
+			name:            "refresh the lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.RefreshLock(fileID, "A") },
+			expectedLockID:  "A",
+			expectedCurrent: "A",
+		},
+		{
+			name:            "refresh another lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.RefreshLock(fileID, "B") },
+			expectedLockID:  "A",
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "A",
+		},
+		{
+			name:            "refresh the lock of an unlocked file",
+			operation:       func(m *WopiLockManager) (string, error) { return m.RefreshLock(fileID, "A") },
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "",
+		},
+		{
+			name:            "unlock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Unlock(fileID, "A") },
+			expectedCurrent: "",
+		},
+		{
+			name:            "unlock another lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.Unlock(fileID, "B") },
+			expectedLockID:  "A",
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "A",
+		},
+		{
+			name:            "write an unlocked file",
+			operation:       func(m *WopiLockManager) (string, error) { return m.CanWrite(fileID, "") },
+			expectedCurrent: "",
+		},
+		{
+			name:            "write with the lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.CanWrite(fileID, "A") },
+			expectedLockID:  "A",
+			expectedCurrent: "A",
+		},
+		{
+			name:            "write without the lock",
+			current:         &WopiLock{LockID: "A", UserID: "user"},
+			operation:       func(m *WopiLockManager) (string, error) { return m.CanWrite(fileID, "B") },
+			expectedLockID:  "A",
+			expectedErr:     errLockMismatch,
+			expectedCurrent: "A",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			m := NewWopiLockManager(api)
+			if test.current != nil {
+				if test.current.ExpiresAt == 0 {
+					test.current.ExpiresAt = model.GetMillis() + WopiLockExpiry.Milliseconds()
+				}
+				data, _ := json.Marshal(test.current)
+				api.kv[getLockKey(fileID)] = data
+			}
+
+			lockID, err := test.operation(m)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+			}
+			if lockID != test.expectedLockID {
+				t.Errorf("expected lock ID %q, got %q", test.expectedLockID, lockID)
+			}
+
+			current, err := m.GetLock(fileID)
+			if err != nil {
+				t.Fatalf("failed to get the lock: %v", err)
+			}
+			if current != test.expectedCurrent {
+				t.Errorf("expected the file to be locked with %q, got %q", test.expectedCurrent, current)
+			}
+		})
+	}
+}
+
+func TestWopiLockManagerConcurrentChange(t *testing.T) {
+	const fileID = "file"
+
+	tests := []struct {
+		name string
+
+		// locked tells if the file is locked with A when the operation reads the lock
+		locked  bool
+		newLock *WopiLock
+	}{
+		{"lock changed while relocking", true, &WopiLock{LockID: "B", UserID: "user"}},
+		{"file locked while locking", false, &WopiLock{LockID: "B", UserID: "user"}},
+		{"lock changed while unlocking", true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			m := NewWopiLockManager(api)
+			expiresAt := model.GetMillis() + WopiLockExpiry.Milliseconds()
+
+			var oldData []byte
+			if test.locked {
+				oldData, _ = json.Marshal(&WopiLock{LockID: "A", UserID: "user", ExpiresAt: expiresAt})
+			}
+
+			// another request locks the file with C between the read and the write
+			api.kv[getLockKey(fileID)], _ = json.Marshal(&WopiLock{LockID: "C", UserID: "other", ExpiresAt: expiresAt})
+
+			if test.newLock != nil {
+				test.newLock.ExpiresAt = expiresAt
+			}
+			lockID, err := m.setLock(fileID, oldData, test.newLock)
+			if !errors.Is(err, errLockMismatch) {
+				t.Fatalf("expected a lock mismatch, got %v", err)
+			}
+			if lockID != "C" {
+				t.Errorf("expected the current lock ID C, got %q", lockID)
+			}
+			if current, _ := m.GetLock(fileID); current != "C" {
+				t.Errorf("expected the concurrent lock to be kept, got %q", current)
+			}
+		})
+	}
+}
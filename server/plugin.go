@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
 )
@@ -15,11 +16,63 @@ type Plugin struct {
 	router            *mux.Router
 	configurationLock sync.RWMutex
 	configuration     *configuration
+	lockManager       *WopiLockManager
+
+	// discovery is the discovery of the Collabora Online server
+	discovery DiscoveryRegistry
+
+	// discoveryStatus tells whether the discovery could be loaded, guarded by discoveryStatusLock along with stopDiscoveryRetryChan
+	discoveryStatusLock    sync.RWMutex
+	discoveryStatus        DiscoveryStatus
+	stopDiscoveryRetryChan chan struct{}
+
+	// stopKeyRotation stops the scheduled key rotation job
+	stopKeyRotation chan struct{}
+
+	// stopDiscoverySync stops the discovery refresh job
+	stopDiscoverySync chan struct{}
+
+	// stopCheckoutExpiry stops the job checking in the expired check-outs
+	stopCheckoutExpiry chan struct{}
+
+	// stopFileVersionPrune stops the job removing the versions of the deleted files and the expired versions
+	stopFileVersionPrune chan struct{}
 }
 
 // OnActivate is called when the plugin is activated
 func (p *Plugin) OnActivate() error {
+	p.lockManager = NewWopiLockManager(p.API)
 	p.router = p.InitAPI()
+
+	p.stopKeyRotation = make(chan struct{})
+	go p.runKeyRotationJob(p.stopKeyRotation)
+
+	p.stopDiscoverySync = make(chan struct{})
+	go p.runDiscoveryJob(p.stopDiscoverySync)
+
+	p.stopCheckoutExpiry = make(chan struct{})
+	go p.runCheckoutExpiryJob(p.stopCheckoutExpiry)
+
+	p.stopFileVersionPrune = make(chan struct{})
+	go p.runFileVersionPruneJob(p.stopFileVersionPrune)
+	return nil
+}
+
+// OnDeactivate is called when the plugin is deactivated
+func (p *Plugin) OnDeactivate() error {
+	if p.stopKeyRotation != nil {
+		close(p.stopKeyRotation)
+	}
+	if p.stopDiscoverySync != nil {
+		close(p.stopDiscoverySync)
+	}
+	if p.stopCheckoutExpiry != nil {
+		close(p.stopCheckoutExpiry)
+	}
+	if p.stopFileVersionPrune != nil {
+		close(p.stopFileVersionPrune)
+	}
+	p.stopDiscoveryRetry()
 	return nil
 }
 
@@ -41,14 +94,42 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to validate configuration")
 	}
 
-	if err := p.LoadWopiFileInfo(configuration.WOPIAddress); err != nil {
-		return errors.Wrap(err, "could not load wopi file info")
+	// keep the previous encryption keys, so that the open editing sessions survive a key change
+	previousKeys, keysErr := p.updateKeyRing(configuration.EncryptionKey, configuration.keyRotationGracePeriod)
+	if keysErr != nil {
+		p.API.LogError("Failed to update the encryption keys. Previous keys won't be accepted.", "Error", keysErr.Error())
 	}
+	configuration.previousEncryptionKeys = previousKeys
+
+	p.resolveWopiAllowList(configuration)
 
 	p.setConfiguration(configuration)
+
+	// don't fail the activation if Collabora Online is unreachable: the plugin runs in degraded mode,
+	// retrying to load the discovery until Collabora Online is reachable again
+	if err := p.LoadWopiFileInfo(configuration.WOPIAddress); err != nil {
+		p.API.LogError("Collabora Online is unreachable, retrying to fetch its discovery.", "Error", err.Error())
+		p.startDiscoveryRetry(configuration.WOPIAddress)
+	} else {
+		p.stopDiscoveryRetry()
+	}
 	return nil
 }
 
+// UserHasLeftChannel revokes the tokens the user got for the files of the channel
+func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	if err := p.RevokeUserChannelTokens(channelMember.UserId, channelMember.ChannelId); err != nil {
+		p.API.LogError("Failed to revoke the tokens of a user who left a channel.", "UserID", channelMember.UserId, "ChannelID", channelMember.ChannelId, "Error", err.Error())
+	}
+}
+
+// UserHasLeftTeam revokes the tokens the user got for the files of the team
+func (p *Plugin) UserHasLeftTeam(c *plugin.Context, teamMember *model.TeamMember, actor *model.User) {
+	if err := p.RevokeUserTeamTokens(teamMember.UserId, teamMember.TeamId); err != nil {
+		p.API.LogError("Failed to revoke the tokens of a user who left a team.", "UserID", teamMember.UserId, "TeamID", teamMember.TeamId, "Error", err.Error())
+	}
+}
+
 // ServeHTTP handles HTTP requests for the plugin.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	p.API.LogDebug("New plugin request:", "Host", r.Host, "RequestURI", r.RequestURI, "Method", r.Method)
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// FileAccess is the decision of the access policy for a user and a file,
+// along with the user, file, post and channel it was decided on.
+// Every route and every token minted by the plugin relies on it, so the rules are applied in a single place.
+type FileAccess struct {
+	User     *model.User
+	FileInfo *model.FileInfo
+	Post     *model.Post
+	Channel  *model.Channel
+
+	CanView    bool
+	CanComment bool
+	CanEdit    bool
+	CanRename  bool
+
+	// CanExport allows to save a copy of the file in the channel, with the "Save As" and "Export as" actions
+	CanExport bool
+
+	// Reasons explains why the access was restricted, for auditing
+	Reasons []string
+
+	// Notice explains to the user why the file is read-only or can't be opened
+	Notice string
+
+	// Checkout is the current check-out of the file, nil if the file isn't checked out
+	Checkout *FileCheckout
+}
+
+const (
+	// RestrictedAccessView opens the files in view-only mode
+	RestrictedAccessView = "view"
+	// RestrictedAccessDeny denies the access to the files
+	RestrictedAccessDeny = "deny"
+)
+
+// accessRule restricts or grants the access to a file. The rules are applied in order,
+// each rule seeing the decision of the previous ones.
+type accessRule struct {
+	name  string
+	apply func(p *Plugin, access *FileAccess)
+}
+
+// accessRules is the access policy, applied in order
+var accessRules = []accessRule{
+	{"channel permissions", (*Plugin).applyChannelPermissions},
+	{"editing policy", (*Plugin).applyEditingPolicy},
+	{"edit restriction", (*Plugin).applyEditRestriction},
+	{"approval", (*Plugin).applyApproval},
+	{"check-out", (*Plugin).applyCheckout},
+	{"restricted access", (*Plugin).applyRestrictedAccess},
+	{"post ownership", (*Plugin).applyPostOwnership},
+}
+
+// restrict records why the access was restricted
+func (a *FileAccess) restrict(rule, reason string) {
+	a.Reasons = append(a.Reasons, rule+": "+reason)
+}
+
+// normalize makes the decision consistent: editing requires commenting, which requires viewing
+func (a *FileAccess) normalize() {
+	a.CanComment = a.CanComment && a.CanView
+	a.CanEdit = a.CanEdit && a.CanComment
+	a.CanRename = a.CanRename && a.CanEdit
+	a.CanExport = a.CanExport && a.CanView
+}
+
+// CanWrite checks if the user can save or lock the file. Only edit access allows writing:
+// comment access opens the file with the view_comment action, and must never give a writable session.
+func (a *FileAccess) CanWrite() bool {
+	return a.CanEdit
+}
+
+// Scope returns the scope of the tokens minted for the user
+func (a *FileAccess) Scope() string {
+	switch {
+	case a.CanEdit:
+		return WopiScopeEdit
+	case a.CanComment:
+		return WopiScopeComment
+	default:
+		return WopiScopeView
+	}
+}
+
+// LimitToScope restricts the access to what a token of the given scope allows.
+// Saving a copy uploads a new file to the channel, so only edit tokens allow it.
+func (a *FileAccess) LimitToScope(scope string) {
+	switch scope {
+	case WopiScopeEdit:
+	case WopiScopeComment:
+		a.CanEdit = false
+		a.CanExport = false
+	default:
+		a.CanComment = false
+		a.CanEdit = false
+		a.CanExport = false
+	}
+	a.normalize()
+}
+
+// getDeniedMessage returns the message sent to the user when an action on the file is denied
+func (a *FileAccess) getDeniedMessage() string {
+	if a.Notice != "" {
+		return a.Notice
+	}
+	return "You do not have the appropriate permissions."
+}
+
+// Permissions returns the permissions sent to the client
+func (a *FileAccess) Permissions() FilePermissions {
+	return FilePermissions{
+		CanView:    a.CanView,
+		CanComment: a.CanComment,
+		CanEdit:    a.CanEdit,
+	}
+}
+
+// getFileAccess decides what the user can do with the file attached to the post
+func (p *Plugin) getFileAccess(userID string, fileInfo *model.FileInfo, post *model.Post) (*FileAccess, error) {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the user")
+	}
+
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the channel of the file")
+	}
+
+	access := &FileAccess{
+		User:     user,
+		FileInfo: fileInfo,
+		Post:     post,
+		Channel:  channel,
+	}
+	for _, rule := range accessRules {
+		rule.apply(p, access)
+	}
+	access.normalize()
+
+	if len(access.Reasons) > 0 {
+		p.API.LogDebug("File access restricted.", "UserID", userID, "FileID", fileInfo.Id, "Scope", access.Scope(), "Reasons", strings.Join(access.Reasons, "; "))
+	}
+
+	return access, nil
+}
+
+// getFileAccessByID decides what the user can do with the file
+func (p *Plugin) getFileAccessByID(userID, fileID string) (*FileAccess, error) {
+	fileInfo, err := p.getFileInfo(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	post, appErr := p.API.GetPost(fileInfo.PostId)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the post of the file")
+	}
+
+	return p.getFileAccess(userID, fileInfo, post)
+}
+
+// getNewFileAccess decides what the user can do with a file the user would create in the channel,
+// so that the policy is applied before the file is uploaded
+func (p *Plugin) getNewFileAccess(userID, channelID, fileName string) (*FileAccess, error) {
+	fileInfo := &model.FileInfo{
+		CreatorId: userID,
+		Name:      fileName,
+		Extension: strings.TrimPrefix(filepath.Ext(fileName), "."),
+	}
+	post := &model.Post{ChannelId: channelID, UserId: userID}
+	return p.getFileAccess(userID, fileInfo, post)
+}
+
+// applyChannelPermissions grants the access following the user permissions in the channel:
+// reading the channel allows to view the file, posting allows to comment on it,
+// and uploading files allows to edit it and to save copies of it in the channel.
+func (p *Plugin) applyChannelPermissions(access *FileAccess) {
+	userID, channelID := access.User.Id, access.Channel.Id
+
+	if access.CanView = p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_READ_CHANNEL); !access.CanView {
+		access.restrict("channel permissions", "the user can't read the channel")
+		return
+	}
+
+	if access.CanComment = p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_CREATE_POST); !access.CanComment {
+		access.restrict("channel permissions", "the user can't post in the channel")
+		return
+	}
+
+	if access.CanEdit = p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_UPLOAD_FILE); !access.CanEdit {
+		access.restrict("channel permissions", "the user can't upload files to the channel")
+		return
+	}
+	access.CanExport = true
+}
+
+// applyRestrictedAccess opens the files in view-only mode, or denies the access to them, for guest users,
+// deactivated users and in archived channels, following the configuration
+func (p *Plugin) applyRestrictedAccess(access *FileAccess) {
+	config := p.getConfiguration()
+
+	var mode, notice string
+	switch {
+	case access.User.DeleteAt != 0:
+		// deactivated users can't open the files, their tokens are revoked as well
+		mode, notice = RestrictedAccessDeny, "Your account is deactivated."
+	case access.Channel.DeleteAt != 0:
+		mode, notice = config.ArchivedChannelAccess, "This file belongs to an archived channel."
+	case access.User.IsGuest():
+		mode, notice = config.GuestAccess, "Guest accounts can't edit files."
+	default:
+		return
+	}
+
+	access.restrict("restricted access", notice)
+	access.Notice = notice
+	access.CanComment = false
+	access.CanEdit = false
+	access.CanExport = false
+	if mode == RestrictedAccessDeny {
+		access.CanView = false
+	}
+}
+
+// applyPostOwnership allows renaming the file following the Mattermost rules for editing the post:
+// the author of the post or a user allowed to edit others' posts.
+func (p *Plugin) applyPostOwnership(access *FileAccess) {
+	permission := model.PERMISSION_EDIT_OTHERS_POSTS
+	if access.Post.UserId == access.User.Id {
+		permission = model.PERMISSION_EDIT_POST
+	}
+
+	access.CanRename = p.API.HasPermissionToChannel(access.User.Id, access.Channel.Id, permission)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// accessFlags are the decisions of a FileAccess, compared by the policy tests
+type accessFlags struct {
+	view, comment, edit, rename, export bool
+}
+
+func flagsOf(access *FileAccess) accessFlags {
+	return accessFlags{access.CanView, access.CanComment, access.CanEdit, access.CanRename, access.CanExport}
+}
+
+func TestApplyChannelPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []*model.Permission
+		expected    accessFlags
+	}{
+		{"no permission", nil, accessFlags{}},
+		{"read", []*model.Permission{model.PERMISSION_READ_CHANNEL}, accessFlags{view: true}},
+		{
+			name:        "read and post",
+			permissions: []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST},
+			expected:    accessFlags{view: true, comment: true},
+		},
+		{
+			name:        "read, post and upload",
+			permissions: []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE},
+			expected:    accessFlags{view: true, comment: true, edit: true, export: true},
+		},
+		{
+			name:        "upload without posting",
+			permissions: []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_UPLOAD_FILE},
+			expected:    accessFlags{view: true},
+		},
+		{
+			name:        "post without reading",
+			permissions: []*model.Permission{model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE},
+			expected:    accessFlags{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			user := api.addUser("system_user")
+			channel, post, fileInfo := api.addFile(model.NewId())
+			api.grant(user.Id, channel.Id, test.permissions...)
+
+			access := &FileAccess{User: user, FileInfo: fileInfo, Post: post, Channel: channel}
+			p.applyChannelPermissions(access)
+			access.normalize()
+
+			if flags := flagsOf(access); flags != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, flags)
+			}
+		})
+	}
+}
+
+func TestLimitToScope(t *testing.T) {
+	full := accessFlags{view: true, comment: true, edit: true, rename: true, export: true}
+	commentOnly := accessFlags{view: true, comment: true}
+
+	tests := []struct {
+		name     string
+		access   accessFlags
+		scope    string
+		expected accessFlags
+	}{
+		{"edit token", full, WopiScopeEdit, full},
+		{"comment token", full, WopiScopeComment, commentOnly},
+		{"view token", full, WopiScopeView, accessFlags{view: true}},
+		{"token without scope", full, "", accessFlags{view: true}},
+		{"edit token of a user who can only comment", commentOnly, WopiScopeEdit, commentOnly},
+		{"edit token of a user who can't view the file", accessFlags{}, WopiScopeEdit, accessFlags{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access := &FileAccess{
+				CanView:    test.access.view,
+				CanComment: test.access.comment,
+				CanEdit:    test.access.edit,
+				CanRename:  test.access.rename,
+				CanExport:  test.access.export,
+			}
+			access.LimitToScope(test.scope)
+
+			if flags := flagsOf(access); flags != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, flags)
+			}
+			if test.scope != WopiScopeEdit && access.CanWrite() {
+				t.Errorf("expected a %q token not to allow writing", test.scope)
+			}
+		})
+	}
+}
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		access   FileAccess
+		expected string
+	}{
+		{FileAccess{CanView: true, CanComment: true, CanEdit: true}, WopiScopeEdit},
+		{FileAccess{CanView: true, CanComment: true}, WopiScopeComment},
+		{FileAccess{CanView: true}, WopiScopeView},
+	}
+
+	for _, test := range tests {
+		if scope := test.access.Scope(); scope != test.expected {
+			t.Errorf("expected the scope %q for %+v, got %q", test.expected, flagsOf(&test.access), scope)
+		}
+	}
+}
+
+func TestApplyRestrictedAccess(t *testing.T) {
+	member := []*model.Permission{model.PERMISSION_READ_CHANNEL, model.PERMISSION_CREATE_POST, model.PERMISSION_UPLOAD_FILE}
+	editor := accessFlags{view: true, comment: true, edit: true, export: true}
+	viewer := accessFlags{view: true}
+
+	tests := []struct {
+		name           string
+		guestAccess    string
+		archivedAccess string
+		roles          string
+		deactivated    bool
+		archived       bool
+		expected       accessFlags
+	}{
+		{"member", RestrictedAccessDeny, RestrictedAccessDeny, model.SYSTEM_USER_ROLE_ID, false, false, editor},
+		{"guest in view mode", RestrictedAccessView, RestrictedAccessView, model.SYSTEM_GUEST_ROLE_ID, false, false, viewer},
+		{"guest denied", RestrictedAccessDeny, RestrictedAccessView, model.SYSTEM_GUEST_ROLE_ID, false, false, accessFlags{}},
+		{"archived channel in view mode", RestrictedAccessDeny, RestrictedAccessView, model.SYSTEM_USER_ROLE_ID, false, true, viewer},
+		{"archived channel denied", RestrictedAccessView, RestrictedAccessDeny, model.SYSTEM_USER_ROLE_ID, false, true, accessFlags{}},
+		{"deactivated user", RestrictedAccessView, RestrictedAccessView, model.SYSTEM_USER_ROLE_ID, true, false, accessFlags{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			p := newTestPlugin(api)
+			p.setConfiguration(&configuration{GuestAccess: test.guestAccess, ArchivedChannelAccess: test.archivedAccess})
+			user := api.addUser(test.roles)
+			if test.deactivated {
+				user.DeleteAt = model.GetMillis()
+			}
+
+			channel, post, fileInfo := api.addFile(model.NewId())
+			if test.archived {
+				channel.DeleteAt = model.GetMillis()
+			}
+			api.grant(user.Id, channel.Id, member...)
+
+			access, err := p.getFileAccess(user.Id, fileInfo, post)
+			if err != nil {
+				t.Fatalf("failed to get the file access: %v", err)
+			}
+			if flags := flagsOf(access); flags != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, flags)
+			}
+			if test.expected != editor && access.Notice == "" {
+				t.Errorf("expected a notice explaining the restriction")
+			}
+		})
+	}
+}
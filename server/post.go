@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// getThreadRootID returns the ID of the root post of the thread of the post
+func getThreadRootID(post *model.Post) string {
+	if post.RootId != "" {
+		return post.RootId
+	}
+	return post.Id
+}
+
+// replyInThread posts the message in the thread of the post, on behalf of the user
+func (p *Plugin) replyInThread(post *model.Post, userID, message string) error {
+	if _, appErr := p.API.CreatePost(&model.Post{
+		ChannelId: post.ChannelId,
+		RootId:    getThreadRootID(post),
+		UserId:    userID,
+		Message:   message,
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to post in the thread of the file")
+	}
+	return nil
+}
+
+// setPostFileDescription shows a description of the file in its post, or removes it if the description is empty.
+// The descriptions of the files of the post are kept in the prop, and shown as attachments with the attachment ID,
+// so that the other attachments of the post are kept.
+func (p *Plugin) setPostFileDescription(post *model.Post, prop string, attachmentID int64, fileID, description string) error {
+	descriptions := map[string]string{}
+	if value, ok := post.GetProp(prop).(map[string]interface{}); ok {
+		for id, value := range value {
+			if value, ok := value.(string); ok {
+				descriptions[id] = value
+			}
+		}
+	}
+
+	if descriptions[fileID] == description {
+		return nil
+	}
+	if description == "" {
+		delete(descriptions, fileID)
+	} else {
+		descriptions[fileID] = description
+	}
+
+	var attachments []*model.SlackAttachment
+	for _, attachment := range post.Attachments() {
+		if attachment.Id != attachmentID {
+			attachments = append(attachments, attachment)
+		}
+	}
+	for _, id := range post.FileIds {
+		if description, ok := descriptions[id]; ok {
+			attachments = append(attachments, &model.SlackAttachment{
+				Id:       attachmentID,
+				Fallback: description,
+				Text:     description,
+			})
+		}
+	}
+
+	if len(descriptions) == 0 {
+		post.DelProp(prop)
+	} else {
+		post.AddProp(prop, descriptions)
+	}
+	model.ParseSlackAttachment(post, attachments)
+	if len(attachments) == 0 {
+		post.DelProp("attachments")
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to update the post of the file")
+	}
+	return nil
+}
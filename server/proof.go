@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	root "github.com/CollaboraOnline/collabora-mattermost"
+)
+
+const (
+	HeaderWopiProof     = "X-WOPI-Proof"
+	HeaderWopiProofOld  = "X-WOPI-ProofOld"
+	HeaderWopiTimestamp = "X-WOPI-TimeStamp"
+
+	// wopiProofMaxAge is the maximum age of a request timestamp, as recommended by the WOPI specification
+	wopiProofMaxAge = 20 * time.Minute
+
+	// wopiProofMaxSkew is how far in the future a request timestamp can be, to allow for a small clock skew
+	wopiProofMaxSkew = 5 * time.Minute
+
+	// ticksPerSecond and ticksToUnixEpoch convert the WOPI timestamps, expressed in .NET ticks
+	// (100 nanoseconds intervals since 0001-01-01), to Unix time
+	ticksPerSecond   = 10000000
+	ticksToUnixEpoch = 621355968000000000
+)
+
+var errInvalidProof = errors.New("invalid WOPI proof")
+
+// ProofKeys are the current and old public keys of the WOPI proof-key element in the discovery XML
+type ProofKeys struct {
+	Current *rsa.PublicKey
+	Old     *rsa.PublicKey
+}
+
+// parseProofKey parses an RSA public key from the base64 encoded modulus and exponent
+func parseProofKey(modulus, exponent string) (*rsa.PublicKey, error) {
+	modulusBytes, err := base64.StdEncoding.DecodeString(modulus)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the proof key modulus")
+	}
+
+	exponentBytes, err := base64.StdEncoding.DecodeString(exponent)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the proof key exponent")
+	}
+
+	publicKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulusBytes),
+		E: int(new(big.Int).SetBytes(exponentBytes).Int64()),
+	}
+	if publicKey.N.Sign() == 0 || publicKey.E == 0 {
+		return nil, errors.New("empty proof key")
+	}
+
+	return publicKey, nil
+}
+
+// NewProofKeys parses the proof keys from the discovery XML.
+// It returns nil if Collabora Online doesn't advertise any proof key.
+func NewProofKeys(discovery *WopiDiscovery) (*ProofKeys, error) {
+	proofKey := discovery.ProofKey
+	if proofKey.Modulus == "" {
+		return nil, nil
+	}
+
+	current, err := parseProofKey(proofKey.Modulus, proofKey.Exponent)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &ProofKeys{Current: current}
+	if proofKey.OldModulus != "" {
+		if keys.Old, err = parseProofKey(proofKey.OldModulus, proofKey.OldExponent); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// getExpectedProof builds the data signed by Collabora Online:
+// the access token, the uppercase request URL and the timestamp, each preceded by its length
+func getExpectedProof(accessToken, url string, timestamp int64) []byte {
+	var proof []byte
+	appendWithLength := func(data []byte) {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(data)))
+		proof = append(proof, length...)
+		proof = append(proof, data...)
+	}
+
+	timestampBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestampBytes, uint64(timestamp))
+
+	appendWithLength([]byte(accessToken))
+	appendWithLength([]byte(strings.ToUpper(url)))
+	appendWithLength(timestampBytes)
+	return proof
+}
+
+func verifyProof(key *rsa.PublicKey, hashed []byte, signature string) bool {
+	if key == nil || signature == "" {
+		return false
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed, signatureBytes) == nil
+}
+
+// Verify checks the proof of a request, accepting the signatures made with either the current or the old key,
+// so that requests remain valid while Collabora Online rotates its keys.
+func (k *ProofKeys) Verify(accessToken, url string, timestamp int64, proof, proofOld string) error {
+	requestTime := time.Unix((timestamp-ticksToUnixEpoch)/ticksPerSecond, 0)
+	if age := time.Since(requestTime); age > wopiProofMaxAge {
+		return errors.Wrap(errInvalidProof, "the request timestamp is too old")
+	} else if age < -wopiProofMaxSkew {
+		return errors.Wrap(errInvalidProof, "the request timestamp is in the future")
+	}
+
+	hashed := sha256.Sum256(getExpectedProof(accessToken, url, timestamp))
+	if verifyProof(k.Current, hashed[:], proof) ||
+		verifyProof(k.Current, hashed[:], proofOld) ||
+		verifyProof(k.Old, hashed[:], proof) {
+		return nil
+	}
+
+	return errors.Wrap(errInvalidProof, "the signature doesn't match")
+}
+
+// getWopiRequestURL returns the URL Collabora Online called, as used to sign the request.
+// The query string is taken from the request URI, as Mattermost removes the access_token from the parsed URL.
+func (p *Plugin) getWopiRequestURL(r *http.Request) string {
+	url := *p.API.GetConfig().ServiceSettings.SiteURL + "/plugins/" + root.Manifest.Id + r.URL.Path
+	if index := strings.Index(r.RequestURI, "?"); index != -1 {
+		url += r.RequestURI[index:]
+	}
+	return url
+}
+
+// withWopiProofValidation verifies that the WOPI requests are signed by the Collabora Online server
+func (p *Plugin) withWopiProofValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		discovery := p.discovery.Get()
+		if !p.getConfiguration().EnableProofKeyValidation {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the requests can't be verified until the discovery is loaded
+		if !discovery.isLoaded() {
+			p.API.LogWarn("Rejected a WOPI request, the discovery of Collabora Online isn't loaded.", "RequestURI", r.URL.Path, "RemoteAddr", r.RemoteAddr)
+			http.Error(w, "Collabora Online is temporarily unavailable.", http.StatusServiceUnavailable)
+			return
+		}
+
+		proofKeys := discovery.ProofKeys
+		if proofKeys == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		accessToken, err := getAccessTokenFromURI(r.RequestURI)
+		if err != nil {
+			http.Error(w, "Invalid token.", http.StatusBadRequest)
+			return
+		}
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderWopiTimestamp), 10, 64)
+		if err != nil {
+			p.API.LogWarn("Rejected a WOPI request without a valid timestamp.", "RequestURI", r.URL.Path, "RemoteAddr", r.RemoteAddr)
+			http.Error(w, "Invalid proof.", http.StatusInternalServerError)
+			return
+		}
+
+		if err := proofKeys.Verify(accessToken, p.getWopiRequestURL(r), timestamp, r.Header.Get(HeaderWopiProof), r.Header.Get(HeaderWopiProofOld)); err != nil {
+			p.API.LogWarn("Rejected a WOPI request with an invalid proof.", "RequestURI", r.URL.Path, "RemoteAddr", r.RemoteAddr, "Error", err.Error())
+			// the WOPI specification requires 500 Internal Server Error for proof validation failures
+			http.Error(w, "Invalid proof.", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessToken = "token"
+	testWopiURL     = "https://mattermost.example.com/plugins/com.collaboraonline.mattermost/api/v1/wopi/files/abc?access_token=token"
+)
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+	return key
+}
+
+func toWopiTimestamp(t time.Time) int64 {
+	return t.Unix()*ticksPerSecond + ticksToUnixEpoch
+}
+
+func signProof(t *testing.T, key *rsa.PrivateKey, timestamp int64) string {
+	t.Helper()
+	hashed := sha256.Sum256(getExpectedProof(testAccessToken, testWopiURL, timestamp))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("failed to sign the proof: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestProofKeysVerify(t *testing.T) {
+	current, old, other := generateTestKey(t), generateTestKey(t), generateTestKey(t)
+	keys := &ProofKeys{Current: &current.PublicKey, Old: &old.PublicKey}
+	now := toWopiTimestamp(time.Now())
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		proof     func(timestamp int64) string
+		proofOld  func(timestamp int64) string
+		valid     bool
+	}{
+		{
+			name:      "signed with the current key",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, current, ts) },
+			valid:     true,
+		},
+		{
+			name:      "old proof signed with the current key, the keys were just rotated by the WOPI client",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, other, ts) },
+			proofOld:  func(ts int64) string { return signProof(t, current, ts) },
+			valid:     true,
+		},
+		{
+			name:      "signed with the old key, the keys were just rotated in the discovery",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, old, ts) },
+			valid:     true,
+		},
+		{
+			name:      "old proof signed with the old key",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, other, ts) },
+			proofOld:  func(ts int64) string { return signProof(t, old, ts) },
+			valid:     false,
+		},
+		{
+			name:      "signed with another key",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, other, ts) },
+			valid:     false,
+		},
+		{
+			name:      "signed for another timestamp",
+			timestamp: now,
+			proof:     func(ts int64) string { return signProof(t, current, ts-ticksPerSecond) },
+			valid:     false,
+		},
+		{
+			name:      "not base64",
+			timestamp: now,
+			proof:     func(int64) string { return "not base64!" },
+			valid:     false,
+		},
+		{
+			name:      "no proof",
+			timestamp: now,
+			proof:     func(int64) string { return "" },
+			valid:     false,
+		},
+		{
+			name:      "stale timestamp",
+			timestamp: toWopiTimestamp(time.Now().Add(-wopiProofMaxAge - time.Minute)),
+			proof:     func(ts int64) string { return signProof(t, current, ts) },
+			valid:     false,
+		},
+		{
+			name:      "timestamp slightly in the future",
+			timestamp: toWopiTimestamp(time.Now().Add(wopiProofMaxSkew - time.Minute)),
+			proof:     func(ts int64) string { return signProof(t, current, ts) },
+			valid:     true,
+		},
+		{
+			name:      "timestamp far in the future",
+			timestamp: toWopiTimestamp(time.Now().Add(wopiProofMaxSkew + time.Minute)),
+			proof:     func(ts int64) string { return signProof(t, current, ts) },
+			valid:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proofOld := ""
+			if test.proofOld != nil {
+				proofOld = test.proofOld(test.timestamp)
+			}
+
+			err := keys.Verify(testAccessToken, testWopiURL, test.timestamp, test.proof(test.timestamp), proofOld)
+			if test.valid && err != nil {
+				t.Errorf("expected a valid proof, got: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an invalid proof")
+			}
+		})
+	}
+}
+
+func TestProofKeysVerifyWithoutOldKey(t *testing.T) {
+	current, other := generateTestKey(t), generateTestKey(t)
+	keys := &ProofKeys{Current: &current.PublicKey}
+	now := toWopiTimestamp(time.Now())
+
+	if err := keys.Verify(testAccessToken, testWopiURL, now, signProof(t, other, now), ""); err == nil {
+		t.Error("expected an invalid proof")
+	}
+	if err := keys.Verify(testAccessToken, testWopiURL, now, signProof(t, current, now), ""); err != nil {
+		t.Errorf("expected a valid proof, got: %v", err)
+	}
+}
+
+func TestNewProofKeys(t *testing.T) {
+	current, old := generateTestKey(t), generateTestKey(t)
+	encode := func(key *rsa.PrivateKey) (string, string) {
+		return base64.StdEncoding.EncodeToString(key.N.Bytes()),
+			base64.StdEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	}
+
+	discovery := &WopiDiscovery{}
+	keys, err := NewProofKeys(discovery)
+	if err != nil || keys != nil {
+		t.Fatalf("expected no proof keys without proof-key element, got: %v, %v", keys, err)
+	}
+
+	discovery.ProofKey.Modulus, discovery.ProofKey.Exponent = encode(current)
+	discovery.ProofKey.OldModulus, discovery.ProofKey.OldExponent = encode(old)
+	keys, err = NewProofKeys(discovery)
+	if err != nil {
+		t.Fatalf("failed to parse the proof keys: %v", err)
+	}
+	if !keys.Current.Equal(&current.PublicKey) || !keys.Old.Equal(&old.PublicKey) {
+		t.Error("the proof keys don't match the discovery")
+	}
+
+	discovery.ProofKey.Modulus = "not base64!"
+	if _, err := NewProofKeys(discovery); err == nil {
+		t.Error("expected an error for an invalid modulus")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// userRevocationsKeyPrefix is the KV store key prefix used for the tokens revoked for a user
+	userRevocationsKeyPrefix = "wopi_revoked_user_"
+
+	// fileRevocationsKeyPrefix is the KV store key prefix used for the tokens revoked for a file
+	fileRevocationsKeyPrefix = "wopi_revoked_file_"
+)
+
+// errTokenRevoked is returned when a token was revoked
+var errTokenRevoked = errors.New("the token was revoked")
+
+// UserTokenRevocations holds the tokens revoked for a user.
+// Tokens are revoked in bulk by recording the revocation time: every token of the user issued
+// before that time is rejected. Single tokens are revoked by their ID (jti claim).
+//
+// The tokens are not single-use: Collabora Online sends the same access token with every WOPI request
+// of an editing session (CheckFileInfo, GetFile, Lock, PutFile...). Replayed requests are rejected by
+// the proof key validation instead, which only accepts recent requests signed by Collabora Online.
+type UserTokenRevocations struct {
+	// RevokedAt revokes all the tokens of the user, in milliseconds since the epoch
+	RevokedAt int64 `json:"revokedAt,omitempty"`
+
+	// Channels revokes the tokens of the user for the files of a channel, by channel ID
+	Channels map[string]int64 `json:"channels,omitempty"`
+
+	// Teams revokes the tokens of the user for the files of a team, by team ID
+	Teams map[string]int64 `json:"teams,omitempty"`
+
+	// Tokens revokes single tokens, mapping the token ID to the token expiry time, in seconds since the epoch
+	Tokens map[string]int64 `json:"tokens,omitempty"`
+}
+
+func getUserRevocationsKey(userID string) string {
+	return userRevocationsKeyPrefix + userID
+}
+
+func getFileRevocationsKey(fileID string) string {
+	return fileRevocationsKeyPrefix + fileID
+}
+
+// updateUserRevocations atomically applies update to the revocations of the user.
+// The revocations older than the token lifetime are dropped, as the tokens they revoke have expired.
+func (p *Plugin) updateUserRevocations(userID string, update func(revocations *UserTokenRevocations)) error {
+	maxAge := p.getConfiguration().accessTokenLifetime.Milliseconds()
+	return p.kvAtomicUpdate(getUserRevocationsKey(userID), func(data []byte) ([]byte, error) {
+		revocations := &UserTokenRevocations{}
+		if data != nil {
+			if err := json.Unmarshal(data, revocations); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal token revocations")
+			}
+		}
+
+		if revocations.Channels == nil {
+			revocations.Channels = map[string]int64{}
+		}
+		if revocations.Teams == nil {
+			revocations.Teams = map[string]int64{}
+		}
+		if revocations.Tokens == nil {
+			revocations.Tokens = map[string]int64{}
+		}
+
+		update(revocations)
+
+		now := time.Now()
+		for _, revokedAt := range []map[string]int64{revocations.Channels, revocations.Teams} {
+			for id, at := range revokedAt {
+				if at+maxAge < model.GetMillisForTime(now) {
+					delete(revokedAt, id)
+				}
+			}
+		}
+		for tokenID, expiresAt := range revocations.Tokens {
+			if expiresAt < now.Unix() {
+				delete(revocations.Tokens, tokenID)
+			}
+		}
+
+		return json.Marshal(revocations)
+	})
+}
+
+// RevokeUserTokens revokes all the tokens issued to the user
+func (p *Plugin) RevokeUserTokens(userID string) error {
+	now := model.GetMillis()
+	return p.updateUserRevocations(userID, func(revocations *UserTokenRevocations) {
+		revocations.RevokedAt = now
+	})
+}
+
+// RevokeUserChannelTokens revokes the tokens issued to the user for the files of a channel
+func (p *Plugin) RevokeUserChannelTokens(userID, channelID string) error {
+	now := model.GetMillis()
+	return p.updateUserRevocations(userID, func(revocations *UserTokenRevocations) {
+		revocations.Channels[channelID] = now
+	})
+}
+
+// RevokeUserTeamTokens revokes the tokens issued to the user for the files of a team
+func (p *Plugin) RevokeUserTeamTokens(userID, teamID string) error {
+	now := model.GetMillis()
+	return p.updateUserRevocations(userID, func(revocations *UserTokenRevocations) {
+		revocations.Teams[teamID] = now
+	})
+}
+
+// RevokeToken revokes a single token
+func (p *Plugin) RevokeToken(wopiToken WopiToken) error {
+	return p.updateUserRevocations(wopiToken.UserID, func(revocations *UserTokenRevocations) {
+		revocations.Tokens[wopiToken.Id] = wopiToken.ExpiresAt
+	})
+}
+
+// RevokeFileTokens revokes all the tokens issued for the file
+func (p *Plugin) RevokeFileTokens(fileID string) error {
+	data, err := json.Marshal(model.GetMillis())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal token revocation")
+	}
+
+	if appErr := p.API.KVSetWithExpiry(getFileRevocationsKey(fileID), data, int64(p.getConfiguration().accessTokenLifetime/time.Second)); appErr != nil {
+		return errors.Wrap(appErr, "failed to save token revocation in KV store")
+	}
+	return nil
+}
+
+// getIssuedAtMillis returns the issue time of the token in milliseconds.
+// The tokens issued before IssuedAtMillis was introduced are considered issued at the start of their second.
+func (t *WopiToken) getIssuedAtMillis() int64 {
+	if t.IssuedAtMillis != 0 {
+		return t.IssuedAtMillis
+	}
+	return t.IssuedAt * 1000
+}
+
+// checkTokenRevocation returns errTokenRevoked if the token was revoked, or the user was deactivated.
+// Mattermost 5.34 has no deactivation hook, so the tokens of deactivated users are rejected here.
+func (p *Plugin) checkTokenRevocation(wopiToken WopiToken, user *model.User, channel *model.Channel) error {
+	if user.DeleteAt != 0 {
+		return errors.Wrap(errTokenRevoked, "the user is deactivated")
+	}
+
+	data, appErr := p.API.KVGet(getUserRevocationsKey(wopiToken.UserID))
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get token revocations from KV store")
+	}
+
+	if data != nil {
+		revocations := &UserTokenRevocations{}
+		if err := json.Unmarshal(data, revocations); err != nil {
+			return errors.Wrap(err, "failed to unmarshal token revocations")
+		}
+
+		issuedAt := wopiToken.getIssuedAtMillis()
+		if issuedAt < revocations.RevokedAt ||
+			issuedAt < revocations.Channels[channel.Id] ||
+			(channel.TeamId != "" && issuedAt < revocations.Teams[channel.TeamId]) {
+			return errTokenRevoked
+		}
+
+		if _, ok := revocations.Tokens[wopiToken.Id]; ok {
+			return errTokenRevoked
+		}
+	}
+
+	data, appErr = p.API.KVGet(getFileRevocationsKey(wopiToken.FileID))
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get token revocations from KV store")
+	}
+
+	if data != nil {
+		var revokedAt int64
+		if err := json.Unmarshal(data, &revokedAt); err != nil {
+			return errors.Wrap(err, "failed to unmarshal token revocations")
+		}
+
+		if wopiToken.getIssuedAtMillis() < revokedAt {
+			return errTokenRevoked
+		}
+	}
+
+	return nil
+}
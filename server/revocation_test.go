@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+func TestCheckTokenRevocation(t *testing.T) {
+	user := &model.User{Id: model.NewId()}
+	channel := &model.Channel{Id: model.NewId(), TeamId: model.NewId()}
+	fileID := model.NewId()
+
+	tests := []struct {
+		name   string
+		revoke func(p *Plugin, token WopiToken) error
+
+		// issuedAfter issues the token after the revocation
+		issuedAfter bool
+		revoked     bool
+	}{
+		{
+			name:   "no revocation",
+			revoke: func(*Plugin, WopiToken) error { return nil },
+		},
+		{
+			name:    "tokens of the user",
+			revoke:  func(p *Plugin, _ WopiToken) error { return p.RevokeUserTokens(user.Id) },
+			revoked: true,
+		},
+		{
+			name:        "token issued after the revocation of the tokens of the user",
+			revoke:      func(p *Plugin, _ WopiToken) error { return p.RevokeUserTokens(user.Id) },
+			issuedAfter: true,
+		},
+		{
+			name:    "tokens of the user for the channel",
+			revoke:  func(p *Plugin, _ WopiToken) error { return p.RevokeUserChannelTokens(user.Id, channel.Id) },
+			revoked: true,
+		},
+		{
+			name:   "tokens of the user for another channel",
+			revoke: func(p *Plugin, _ WopiToken) error { return p.RevokeUserChannelTokens(user.Id, model.NewId()) },
+		},
+		{
+			name:    "tokens of the user for the team",
+			revoke:  func(p *Plugin, _ WopiToken) error { return p.RevokeUserTeamTokens(user.Id, channel.TeamId) },
+			revoked: true,
+		},
+		{
+			name:   "tokens of another user",
+			revoke: func(p *Plugin, _ WopiToken) error { return p.RevokeUserTokens(model.NewId()) },
+		},
+		{
+			name:    "tokens of the file",
+			revoke:  func(p *Plugin, _ WopiToken) error { return p.RevokeFileTokens(fileID) },
+			revoked: true,
+		},
+		{
+			name:        "token issued after the revocation of the tokens of the file",
+			revoke:      func(p *Plugin, _ WopiToken) error { return p.RevokeFileTokens(fileID) },
+			issuedAfter: true,
+		},
+		{
+			name:    "single token",
+			revoke:  func(p *Plugin, token WopiToken) error { return p.RevokeToken(token) },
+			revoked: true,
+		},
+		{
+			name: "another token",
+			revoke: func(p *Plugin, token WopiToken) error {
+				token.Id = model.NewId()
+				return p.RevokeToken(token)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPlugin(newTestAPI())
+			p.setConfiguration(&configuration{accessTokenLifetime: time.Hour})
+
+			now := time.Now()
+			token := WopiToken{
+				UserID:         user.Id,
+				FileID:         fileID,
+				Scope:          WopiScopeEdit,
+				IssuedAtMillis: model.GetMillisForTime(now) - 1,
+				StandardClaims: jwt.StandardClaims{
+					Id:        model.NewId(),
+					IssuedAt:  now.Unix(),
+					ExpiresAt: now.Add(time.Hour).Unix(),
+				},
+			}
+
+			if err := test.revoke(p, token); err != nil {
+				t.Fatalf("failed to revoke the tokens: %v", err)
+			}
+			if test.issuedAfter {
+				token.IssuedAtMillis = model.GetMillis() + 1
+			}
+
+			err := p.checkTokenRevocation(token, user, channel)
+			if revoked := errors.Is(err, errTokenRevoked); revoked != test.revoked {
+				t.Errorf("expected the token to be revoked: %v, got %v", test.revoked, err)
+			}
+		})
+	}
+}
+
+func TestCheckTokenRevocationDeactivatedUser(t *testing.T) {
+	p := newTestPlugin(newTestAPI())
+	user := &model.User{Id: model.NewId(), DeleteAt: model.GetMillis()}
+	channel := &model.Channel{Id: model.NewId()}
+	token := WopiToken{UserID: user.Id, FileID: model.NewId(), IssuedAtMillis: model.GetMillis()}
+
+	if err := p.checkTokenRevocation(token, user, channel); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("expected the tokens of a deactivated user to be revoked, got %v", err)
+	}
+}
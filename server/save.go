@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WebsocketEventFileUpdated is sent to the channel members when the contents of a file change
+	WebsocketEventFileUpdated = "file_updated"
+)
+
+// FileSave describes a change of the contents of a file
+type FileSave struct {
+	FileInfo *model.FileInfo
+	Post     *model.Post
+	UserID   string
+	Data     []byte
+
+	// IsModifiedByUser, IsAutosave and IsExitSave are the flags sent by Collabora Online with the save
+	IsModifiedByUser bool
+	IsAutosave       bool
+	IsExitSave       bool
+
+	// Metadata is the file metadata after the save, set once the contents are written
+	Metadata *FileMetadata
+}
+
+// postSaveStep is run after the contents of a file were written.
+// Errors are logged but don't fail the save, as the contents are already written.
+type postSaveStep struct {
+	name string
+	run  func(p *Plugin, save *FileSave) error
+}
+
+// postSaveSteps is the pipeline run after every save, in order
+var postSaveSteps = []postSaveStep{
+	{"notify clients", (*Plugin).publishFileUpdatedEvent},
+	{"record the edit", (*Plugin).recordFileEdit},
+}
+
+// saveFileContents overwrites the contents of the file:
+// the current contents are kept as a previous version, the new contents are written,
+// the file metadata is updated and then the post-save pipeline is run.
+func (p *Plugin) saveFileContents(save *FileSave) error {
+	fileInfo := save.FileInfo
+	metadata, err := p.getOrCreateFileMetadata(fileInfo.Id)
+	if err != nil {
+		return err
+	}
+
+	// keep the current contents before overwriting them
+	if _, err = p.saveFileVersion(fileInfo, metadata, save.Post.UserId); err != nil {
+		return errors.Wrap(err, "failed to save the previous version of the file")
+	}
+
+	size, err := p.WriteFile(bytes.NewReader(save.Data), fileInfo.Path)
+	if err != nil {
+		return errors.Wrap(err, "failed to write the file contents")
+	}
+
+	save.Metadata, err = p.updateFileMetadata(fileInfo.Id, func(metadata *FileMetadata) {
+		metadata.Version++
+		metadata.ModifiedBy = save.UserID
+		metadata.ModifiedAt = model.GetMillis()
+		metadata.IsAutosave = save.IsAutosave
+		metadata.Size = size
+		if fileInfo.MimeType == "" {
+			metadata.MimeType = mime.TypeByExtension("." + fileInfo.Extension)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	save.Metadata.applyTo(fileInfo)
+
+	for _, step := range postSaveSteps {
+		if err := step.run(p, save); err != nil {
+			p.API.LogWarn("Post-save step failed.", "Step", step.name, "FileID", fileInfo.Id, "Error", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// publishFileUpdatedEvent notifies the members of the channel that the file changed, so the clients can refresh it.
+// The plugin API can't update the Mattermost FileInfo, so the clients only see the changes through this event.
+func (p *Plugin) publishFileUpdatedEvent(save *FileSave) error {
+	p.API.PublishWebSocketEvent(WebsocketEventFileUpdated, map[string]interface{}{
+		"file_id":     save.FileInfo.Id,
+		"post_id":     save.Post.Id,
+		"name":        save.FileInfo.Name,
+		"size":        save.FileInfo.Size,
+		"mime_type":   save.FileInfo.MimeType,
+		"update_at":   save.FileInfo.UpdateAt,
+		"modified_by": save.UserID,
+		"version":     save.Metadata.Version,
+	}, &model.WebsocketBroadcast{ChannelId: save.Post.ChannelId})
+	return nil
+}
@@ -12,6 +12,12 @@ import (
 type WopiToken struct {
 	UserID string `json:"userId"`
 	FileID string `json:"fileId"`
+	Scope  string `json:"scope"` // view, comment or edit
+
+	// IssuedAtMillis is the issue time in milliseconds, as the iat claim is in seconds
+	// and a token issued right after a revocation must not be revoked by it
+	IssuedAtMillis int64 `json:"iatMillis,omitempty"`
+
 	jwt.StandardClaims
 }
 
@@ -33,6 +39,15 @@ type WopiDiscovery struct {
 			} `xml:"action"`
 		} `xml:"app"`
 	} `xml:"net-zone"`
+	ProofKey struct {
+		Text        string `xml:",chardata"`
+		Value       string `xml:"value,attr"`
+		Modulus     string `xml:"modulus,attr"`
+		Exponent    string `xml:"exponent,attr"`
+		OldValue    string `xml:"oldvalue,attr"`
+		OldModulus  string `xml:"oldmodulus,attr"`
+		OldExponent string `xml:"oldexponent,attr"`
+	} `xml:"proof-key"`
 }
 
 // WopiCheckFileInfo is the required response from http:// wopi.readthedocs.io/projects/wopirest/en/latest/files/CheckFileInfo.html#checkfileinfo
@@ -57,12 +72,60 @@ type WopiCheckFileInfo struct {
 
 	// Enables/disables the "Save As" acton in the File menu
 	UserCanNotWriteRelative bool `json:"UserCanNotWriteRelative"`
+
+	// Indicates that the host supports the Lock, Unlock, RefreshLock and UnlockAndRelock operations
+	SupportsLocks bool `json:"SupportsLocks"`
+
+	// Indicates that the host supports the GetLock operation
+	SupportsGetLock bool `json:"SupportsGetLock"`
+
+	// Indicates that the host supports the RenameFile operation
+	SupportsRename bool `json:"SupportsRename"`
+
+	// Indicates that the user has permission to rename the file
+	UserCanRename bool `json:"UserCanRename"`
+
+	// The name of the document to create when a template file is opened, saved with PutRelativeFile
+	TemplateSaveAs string `json:"TemplateSaveAs,omitempty"`
+
+	// The current version of the file, changing every time the file contents change
+	Version string `json:"Version"`
+
+	// The last time the file was modified, in ISO 8601 format
+	LastModifiedTime string `json:"LastModifiedTime"`
+}
+
+// WopiPutFileResponse is the response to PutFile, expected by Collabora Online to track the file modification time
+type WopiPutFileResponse struct {
+	LastModifiedTime string `json:"LastModifiedTime"`
+}
+
+// WopiConflictResponse is the response to PutFile when the file was modified since Collabora Online loaded it
+// see: https://sdk.collaboraonline.com/docs/advanced_integration.html#detecting-external-document-change
+type WopiConflictResponse struct {
+	COOLStatusCode int `json:"COOLStatusCode"`
+	LOOLStatusCode int `json:"LOOLStatusCode"` // used by Collabora Online versions before 21.11
+}
+
+// WopiPutRelativeFileResponse is the required response from https://wopi.readthedocs.io/projects/wopirest/en/latest/files/PutRelativeFile.html
+type WopiPutRelativeFileResponse struct {
+	// The string name of the file, including extension, without a path.
+	Name string `json:"Name"`
+
+	// A URI that is the WOPI src for the new file, including the access token.
+	URL string `json:"Url"`
+}
+
+// WopiRenameFileResponse is the required response from https://wopi.readthedocs.io/projects/wopirest/en/latest/files/RenameFile.html
+type WopiRenameFileResponse struct {
+	// The name of the renamed file, without the file extension.
+	Name string `json:"Name"`
 }
 
-// WopiFile is used top map file extension with the action & url
-type WopiFile struct {
-	URL    string // WOPI url to view/edit the file
-	Action string // edit or view
+// WopiApp is a Collabora Online application handling a file extension or a MIME type, with the URLs of its actions
+type WopiApp struct {
+	Name    string            `json:"name"`    // writer, calc, impress, draw or a MIME type
+	Actions map[string]string `json:"actions"` // maps the action (edit, view, view_comment) to its WOPI url
 }
 
 // ClientFileInfo contains file information sent to the client
@@ -70,5 +133,13 @@ type ClientFileInfo struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
 	Extension string `json:"extension"`
-	Action    string `json:"action"` // view or edit
+	Action    string `json:"action"` // the discovery action used to open the file: edit, view or view_comment
+	FilePermissions
+}
+
+// FilePermissions tells the client what the user can do with a file, so the UI matches what the server allows
+type FilePermissions struct {
+	CanView    bool `json:"can_view"`
+	CanComment bool `json:"can_comment"`
+	CanEdit    bool `json:"can_edit"`
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// testAPI is an in-memory implementation of the parts of the plugin API used by the tests.
+// Calling a method it doesn't implement panics, through the nil embedded interface.
+type testAPI struct {
+	plugin.API
+
+	lock sync.Mutex
+	kv   map[string][]byte
+
+	users    map[string]*model.User
+	channels map[string]*model.Channel
+	posts    map[string]*model.Post
+	files    map[string]*model.FileInfo
+
+	// permissions lists the permissions of each user in each channel, keyed by user ID and channel ID
+	permissions map[string]map[string][]*model.Permission
+
+	// createdPosts are the posts created by the plugin
+	createdPosts []*model.Post
+
+	// failures makes the methods fail with the error, keyed by method name
+	failures map[string]*model.AppError
+
+	config *model.Config
+}
+
+func newTestAPI() *testAPI {
+	config := &model.Config{}
+	config.SetDefaults()
+
+	return &testAPI{
+		kv:          map[string][]byte{},
+		users:       map[string]*model.User{},
+		channels:    map[string]*model.Channel{},
+		posts:       map[string]*model.Post{},
+		files:       map[string]*model.FileInfo{},
+		permissions: map[string]map[string][]*model.Permission{},
+		failures:    map[string]*model.AppError{},
+		config:      config,
+	}
+}
+
+func notFoundError(where string) *model.AppError {
+	return model.NewAppError(where, "app.not_found", nil, "", http.StatusNotFound)
+}
+
+func (a *testAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.kv[key], nil
+}
+
+func (a *testAPI) KVSet(key string, value []byte) *model.AppError {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.kv[key] = value
+	return nil
+}
+
+func (a *testAPI) KVSetWithExpiry(key string, value []byte, _ int64) *model.AppError {
+	return a.KVSet(key, value)
+}
+
+func (a *testAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if options.Atomic {
+		current, exists := a.kv[key]
+		if options.OldValue == nil && exists || options.OldValue != nil && !bytes.Equal(current, options.OldValue) {
+			return false, nil
+		}
+	}
+
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *testAPI) KVCompareAndDelete(key string, oldValue []byte) (bool, *model.AppError) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	current, exists := a.kv[key]
+	if !exists || !bytes.Equal(current, oldValue) {
+		return false, nil
+	}
+	delete(a.kv, key)
+	return true, nil
+}
+
+func (a *testAPI) KVDelete(key string) *model.AppError {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *testAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	keys := make([]string, 0, len(a.kv))
+	for key := range a.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start, end := page*perPage, (page+1)*perPage
+	if start > len(keys) {
+		return []string{}, nil
+	}
+	if end > len(keys) {
+		end = len(keys)
+	}
+	return keys[start:end], nil
+}
+
+// kvKeysWithPrefix returns the keys of the KV store starting with the prefix
+func (a *testAPI) kvKeysWithPrefix(prefix string) []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var keys []string
+	for key := range a.kv {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (a *testAPI) LogDebug(string, ...interface{}) {}
+func (a *testAPI) LogInfo(string, ...interface{})  {}
+func (a *testAPI) LogWarn(string, ...interface{})  {}
+func (a *testAPI) LogError(string, ...interface{}) {}
+
+func (a *testAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	if user, ok := a.users[userID]; ok {
+		return user, nil
+	}
+	return nil, notFoundError("GetUser")
+}
+
+func (a *testAPI) GetChannel(channelID string) (*model.Channel, *model.AppError) {
+	if channel, ok := a.channels[channelID]; ok {
+		return channel, nil
+	}
+	return nil, notFoundError("GetChannel")
+}
+
+// storedPost returns a copy of the post as read back from the database, its props decoded from JSON
+func storedPost(post *model.Post) *model.Post {
+	return model.PostFromJson(strings.NewReader(post.ToJson()))
+}
+
+func (a *testAPI) GetPost(postID string) (*model.Post, *model.AppError) {
+	if appErr := a.failures["GetPost"]; appErr != nil {
+		return nil, appErr
+	}
+	if post, ok := a.posts[postID]; ok {
+		return storedPost(post), nil
+	}
+	return nil, notFoundError("GetPost")
+}
+
+func (a *testAPI) UpdatePost(post *model.Post) (*model.Post, *model.AppError) {
+	if _, ok := a.posts[post.Id]; !ok {
+		return nil, notFoundError("UpdatePost")
+	}
+	a.posts[post.Id] = storedPost(post)
+	return post, nil
+}
+
+func (a *testAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	post = storedPost(post)
+	post.Id = model.NewId()
+	a.createdPosts = append(a.createdPosts, post)
+	a.posts[post.Id] = post
+	return storedPost(post), nil
+}
+
+func (a *testAPI) GetFileInfo(fileID string) (*model.FileInfo, *model.AppError) {
+	if appErr := a.failures["GetFileInfo"]; appErr != nil {
+		return nil, appErr
+	}
+	if fileInfo, ok := a.files[fileID]; ok {
+		copied := *fileInfo
+		return &copied, nil
+	}
+	return nil, notFoundError("GetFileInfo")
+}
+
+func (a *testAPI) HasPermissionToChannel(userID, channelID string, permission *model.Permission) bool {
+	for _, granted := range a.permissions[userID][channelID] {
+		if granted.Id == permission.Id {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *testAPI) PublishWebSocketEvent(string, map[string]interface{}, *model.WebsocketBroadcast) {}
+
+func (a *testAPI) GetConfig() *model.Config {
+	return a.config
+}
+
+func (a *testAPI) GetUnsanitizedConfig() *model.Config {
+	return a.config
+}
+
+func (a *testAPI) GetLicense() *model.License {
+	return nil
+}
+
+// useLocalFileStore stores the files in the directory
+func (a *testAPI) useLocalFileStore(directory string) {
+	*a.config.FileSettings.DriverName = model.IMAGE_DRIVER_LOCAL
+	*a.config.FileSettings.Directory = directory
+}
+
+// grant gives the permissions to the user in the channel
+func (a *testAPI) grant(userID, channelID string, permissions ...*model.Permission) {
+	if a.permissions[userID] == nil {
+		a.permissions[userID] = map[string][]*model.Permission{}
+	}
+	a.permissions[userID][channelID] = append(a.permissions[userID][channelID], permissions...)
+}
+
+// addFile adds a channel, a post by the author and a file attached to it
+func (a *testAPI) addFile(authorID string) (*model.Channel, *model.Post, *model.FileInfo) {
+	channel := &model.Channel{Id: model.NewId(), TeamId: model.NewId(), Type: model.CHANNEL_OPEN}
+	fileInfo := &model.FileInfo{Id: model.NewId(), CreatorId: authorID, Name: "report.docx", Extension: "docx"}
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id, UserId: authorID, FileIds: model.StringArray{fileInfo.Id}}
+	fileInfo.PostId = post.Id
+
+	a.channels[channel.Id] = channel
+	a.posts[post.Id] = post
+	a.files[fileInfo.Id] = fileInfo
+	return channel, post, fileInfo
+}
+
+// addUser adds a user with the roles
+func (a *testAPI) addUser(roles string) *model.User {
+	user := &model.User{Id: model.NewId(), Username: "user" + model.NewId()[:6], Roles: roles}
+	a.users[user.Id] = user
+	return user
+}
+
+// newTestPlugin creates a plugin using the test API, with the default configuration
+func newTestPlugin(api *testAPI) *Plugin {
+	p := &Plugin{lockManager: NewWopiLockManager(api)}
+	p.SetAPI(api)
+	return p
+}
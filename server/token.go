@@ -2,32 +2,61 @@ package main
 
 import (
 	"net/url"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/pkg/errors"
 )
 
+const (
+	// WopiScopeView allows to view the file
+	WopiScopeView = "view"
+	// WopiScopeComment allows to view the file and add comments to it
+	WopiScopeComment = "comment"
+	// WopiScopeEdit allows to view and edit the file
+	WopiScopeEdit = "edit"
+)
+
 // EncodeToken creates a token for WOPI
-func (p *Plugin) EncodeToken(userID string, fileID string) string {
+// returns the token and its expiry time in milliseconds since the epoch
+func (p *Plugin) EncodeToken(userID string, fileID string, scope string) (string, int64) {
 	config := p.getConfiguration()
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), &WopiToken{
-		UserID: userID,
-		FileID: fileID,
+	now := time.Now()
+	expiresAt := now.Add(config.accessTokenLifetime)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &WopiToken{
+		UserID:         userID,
+		FileID:         fileID,
+		Scope:          scope,
+		IssuedAtMillis: model.GetMillisForTime(now),
+		StandardClaims: jwt.StandardClaims{
+			Id:        model.NewId(),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
 	})
+	token.Header["kid"] = getKeyID(config.EncryptionKey)
 	signedString, err := token.SignedString([]byte(config.EncryptionKey))
 	if err != nil {
 		p.API.LogError("Failed to encode WOPI token.", "Error", err.Error())
-		return ""
+		return "", 0
 	}
-	return signedString
+	return signedString, expiresAt.Unix() * 1000
 }
 
 // DecodeToken decodes a token string an returns WopiToken and isValid
 func (p *Plugin) DecodeToken(tokenString string) (WopiToken, bool) {
 	config := p.getConfiguration()
 	wopiToken := WopiToken{}
-	_, err := jwt.ParseWithClaims(tokenString, &wopiToken, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.EncryptionKey), nil
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	_, err := parser.ParseWithClaims(tokenString, &wopiToken, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := config.getVerificationKey(keyID)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(key), nil
 	})
 
 	if err != nil {
@@ -35,6 +64,12 @@ func (p *Plugin) DecodeToken(tokenString string) (WopiToken, bool) {
 		return WopiToken{}, false
 	}
 
+	// tokens issued before expiry was introduced are not accepted
+	if wopiToken.ExpiresAt == 0 {
+		p.API.LogError("Failed to decode WOPI token.", "Error", "the token has no expiry")
+		return WopiToken{}, false
+	}
+
 	return wopiToken, true
 }
 
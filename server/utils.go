@@ -2,12 +2,20 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"io"
 	"net/http"
+	"strings"
+	"unicode/utf16"
 
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/shared/filestore"
+	"github.com/pkg/errors"
 )
 
+// kvAtomicUpdateMaxAttempts is the number of times an atomic KV update is retried on concurrent modification
+const kvAtomicUpdateMaxAttempts = 5
+
 func (p *Plugin) getFileBackend() (filestore.FileBackend, error) {
 	license := p.API.GetLicense()
 	serverConfig := p.API.GetUnsanitizedConfig()
@@ -31,6 +39,34 @@ func (p *Plugin) WriteFile(fr io.Reader, path string) (int64, error) {
 	return result, nil
 }
 
+// kvAtomicUpdate updates the value stored under key using a compare-and-set operation,
+// retrying if the value was modified concurrently. update receives the current value (nil if not set)
+// and returns the new value to store.
+func (p *Plugin) kvAtomicUpdate(key string, update func(data []byte) ([]byte, error)) error {
+	for i := 0; i < kvAtomicUpdateMaxAttempts; i++ {
+		oldData, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get value from KV store")
+		}
+
+		newData, err := update(oldData)
+		if err != nil {
+			return err
+		}
+
+		saved, appErr := p.API.KVSetWithOptions(key, newData, model.PluginKVSetOptions{Atomic: true, OldValue: oldData})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to save value in KV store")
+		}
+
+		if saved {
+			return nil
+		}
+	}
+
+	return errors.Errorf("failed to update %s: too many concurrent modifications", key)
+}
+
 func (p *Plugin) GetHTTPClient() *http.Client {
 	config := p.getConfiguration()
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
@@ -42,3 +78,46 @@ func (p *Plugin) GetHTTPClient() *http.Client {
 	client := &http.Client{Transport: customTransport}
 	return client
 }
+
+// decodeUTF7 decodes a UTF-7 (RFC 2152) encoded string.
+// WOPI clients send the file names in the X-WOPI-SuggestedTarget and X-WOPI-RelativeTarget headers UTF-7 encoded.
+func decodeUTF7(s string) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			result.WriteByte(s[i])
+			continue
+		}
+
+		end := strings.IndexFunc(s[i+1:], func(r rune) bool {
+			return !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/", r)
+		})
+		if end == -1 {
+			end = len(s) - i - 1
+		}
+		encoded := s[i+1 : i+1+end]
+		i += end
+		// the optional '-' terminates the base64 sequence and is absorbed
+		if i+1 < len(s) && s[i+1] == '-' {
+			i++
+		}
+
+		// "+-" encodes a literal '+'
+		if encoded == "" {
+			result.WriteByte('+')
+			continue
+		}
+
+		decoded, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", err
+		}
+
+		units := make([]uint16, 0, len(decoded)/2)
+		for j := 0; j+1 < len(decoded); j += 2 {
+			units = append(units, uint16(decoded[j])<<8|uint16(decoded[j+1]))
+		}
+		result.WriteString(string(utf16.Decode(units)))
+	}
+	return result.String(), nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestDecodeUTF7(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"ASCII", "report.odt", "report.odt", true},
+		{"empty", "", "", true},
+		{"literal plus", "1+-1.odt", "1+1.odt", true},
+		{"plus at the end", "a+", "a+", true},
+		{"encoded with terminator", "Hi Mom -+Jjo--!", "Hi Mom -☺-!", true},
+		{"encoded without terminator", "A+ImIDkQ.", "A≢Α.", true},
+		{"non-latin characters", "+ZeVnLIqe-.odt", "日本語.odt", true},
+		{"accented characters", "r+AOk-sum+AOk-.odt", "résumé.odt", true},
+		{"invalid base64", "+A-", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := decodeUTF7(test.input)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %q", decoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decoded != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	root "github.com/CollaboraOnline/collabora-mattermost"
+)
+
+const (
+	// fileVersionsKeyPrefix is the KV store key prefix used for the index of file versions
+	fileVersionsKeyPrefix = "file_versions_"
+
+	// maxFileVersions is the maximum number of previous versions kept for a file
+	maxFileVersions = 50
+
+	// fileVersionRetention is how long the previous versions of a file are kept
+	fileVersionRetention = 90 * 24 * time.Hour
+
+	// fileVersionPruneInterval is how often the versions of the deleted files and the expired versions are removed
+	fileVersionPruneInterval = 24 * time.Hour
+
+	// fileVersionPruneLockKey is the KV store key used to make sure only one server of the cluster prunes the versions
+	fileVersionPruneLockKey = "versions_prune_lock"
+
+	// kvListPageSize is the number of keys read at once when listing the KV store
+	kvListPageSize = 200
+)
+
+// FileVersion is a previous version of a file, saved before its contents were overwritten
+type FileVersion struct {
+	ID         string `json:"id"`
+	UserID     string `json:"userId"`   // the user who saved this version
+	CreateAt   int64  `json:"createAt"` // the time this version was saved
+	Size       int64  `json:"size"`
+	IsAutosave bool   `json:"isAutosave"`
+}
+
+func getFileVersionsKey(fileID string) string {
+	return fileVersionsKeyPrefix + fileID
+}
+
+// getFileVersionPath returns the path in the file backend where the contents of the version are stored
+func getFileVersionPath(fileID, versionID string) string {
+	return path.Join(getFileVersionsDirectory(fileID), versionID)
+}
+
+// getFileVersions returns the previous versions of the file, the most recent first
+func (p *Plugin) getFileVersions(fileID string) ([]*FileVersion, error) {
+	data, appErr := p.API.KVGet(getFileVersionsKey(fileID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get file versions from KV store")
+	}
+
+	versions := []*FileVersion{}
+	if data == nil {
+		return versions, nil
+	}
+
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal file versions")
+	}
+	return versions, nil
+}
+
+// getFileVersionByID returns the version of the file with the given ID, or nil if it doesn't exist
+func (p *Plugin) getFileVersionByID(fileID, versionID string) (*FileVersion, error) {
+	versions, err := p.getFileVersions(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		if version.ID == versionID {
+			return version, nil
+		}
+	}
+	return nil, nil
+}
+
+// saveFileVersion keeps a copy of the current contents of the file as a new version.
+// The author of the current contents is taken from the file metadata, falling back to ownerID.
+func (p *Plugin) saveFileVersion(fileInfo *model.FileInfo, metadata *FileMetadata, ownerID string) (*FileVersion, error) {
+	backend, err := p.getFileBackend()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the file backend")
+	}
+
+	version := &FileVersion{
+		ID:         model.NewId(),
+		UserID:     ownerID,
+		CreateAt:   fileInfo.UpdateAt,
+		IsAutosave: metadata.IsAutosave,
+	}
+	if metadata.ModifiedBy != "" {
+		version.UserID = metadata.ModifiedBy
+	}
+
+	if version.Size, err = backend.FileSize(fileInfo.Path); err != nil {
+		return nil, errors.Wrap(err, "failed to get the file size")
+	}
+
+	if err = backend.CopyFile(fileInfo.Path, getFileVersionPath(fileInfo.Id, version.ID)); err != nil {
+		return nil, errors.Wrap(err, "failed to copy the file contents")
+	}
+
+	var removedVersions []*FileVersion
+	err = p.kvAtomicUpdate(getFileVersionsKey(fileInfo.Id), func(data []byte) ([]byte, error) {
+		versions := []*FileVersion{}
+		if data != nil {
+			if err := json.Unmarshal(data, &versions); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal file versions")
+			}
+		}
+
+		versions = append([]*FileVersion{version}, versions...)
+		removedVersions = nil
+		if len(versions) > maxFileVersions {
+			removedVersions = versions[maxFileVersions:]
+			versions = versions[:maxFileVersions]
+		}
+		return json.Marshal(versions)
+	})
+	if err != nil {
+		_ = backend.RemoveFile(getFileVersionPath(fileInfo.Id, version.ID))
+		return nil, errors.Wrap(err, "failed to save the file version")
+	}
+
+	for _, removedVersion := range removedVersions {
+		if err := backend.RemoveFile(getFileVersionPath(fileInfo.Id, removedVersion.ID)); err != nil {
+			p.API.LogWarn("Failed to remove old file version.", "FileID", fileInfo.Id, "VersionID", removedVersion.ID, "Error", err.Error())
+		}
+	}
+
+	return version, nil
+}
+
+// readFileVersion returns the contents of the version of the file
+func (p *Plugin) readFileVersion(fileID, versionID string) ([]byte, error) {
+	backend, err := p.getFileBackend()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the file backend")
+	}
+
+	return backend.ReadFile(getFileVersionPath(fileID, versionID))
+}
+
+// getFileVersionsDirectory returns the directory in the file backend where the versions of the file are stored
+func getFileVersionsDirectory(fileID string) string {
+	return path.Join("plugins", root.Manifest.Id, "versions", fileID)
+}
+
+// runFileVersionPruneJob removes the versions of the deleted files and the expired versions.
+// It stops when the stop channel is closed.
+func (p *Plugin) runFileVersionPruneJob(stop <-chan struct{}) {
+	ticker := time.NewTicker(fileVersionPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// make sure only one server of the cluster prunes the versions
+			locked, appErr := p.API.KVSetWithOptions(fileVersionPruneLockKey, []byte("locked"), model.PluginKVSetOptions{
+				Atomic:          true,
+				ExpireInSeconds: int64(fileVersionPruneInterval / time.Second),
+			})
+			if appErr != nil {
+				p.API.LogError("Failed to acquire the file version prune lock.", "Error", appErr.Error())
+				continue
+			}
+			if !locked {
+				continue
+			}
+
+			if err := p.pruneFileVersions(); err != nil {
+				p.API.LogError("Failed to prune the file versions.", "Error", err.Error())
+			}
+		}
+	}
+}
+
+// pruneFileVersions removes all the versions of the files that were deleted, along with their posts,
+// and the versions older than the retention of the other files
+func (p *Plugin) pruneFileVersions() error {
+	// the keys are listed before being deleted, so that no page is skipped
+	var fileIDs []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to list the KV store keys")
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, fileVersionsKeyPrefix) {
+				fileIDs = append(fileIDs, strings.TrimPrefix(key, fileVersionsKeyPrefix))
+			}
+		}
+		if len(keys) < kvListPageSize {
+			break
+		}
+	}
+
+	for _, fileID := range fileIDs {
+		deleted, err := p.isFileDeleted(fileID)
+		if err != nil {
+			// the file may still exist, its versions are kept until the next run
+			p.API.LogWarn("Failed to check if the file was deleted.", "FileID", fileID, "Error", err.Error())
+			continue
+		}
+
+		if deleted {
+			err = p.removeFileVersions(fileID)
+		} else {
+			err = p.removeExpiredFileVersions(fileID, model.GetMillis()-fileVersionRetention.Milliseconds())
+		}
+		if err != nil {
+			p.API.LogWarn("Failed to prune the versions of the file.", "FileID", fileID, "Error", err.Error())
+		}
+	}
+	return nil
+}
+
+// isFileDeleted checks if the file or its post was deleted. Other errors are returned, so that
+// a transient failure never removes the versions of an existing file.
+func (p *Plugin) isFileDeleted(fileID string) (bool, error) {
+	fileInfo, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return false, appErr
+	}
+	if fileInfo.DeleteAt != 0 {
+		return true, nil
+	}
+
+	post, appErr := p.API.GetPost(fileInfo.PostId)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return false, appErr
+	}
+	return post.DeleteAt != 0, nil
+}
+
+// removeFileVersions removes all the versions of a deleted file
+func (p *Plugin) removeFileVersions(fileID string) error {
+	backend, err := p.getFileBackend()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the file backend")
+	}
+
+	if err := backend.RemoveDirectory(getFileVersionsDirectory(fileID)); err != nil {
+		return errors.Wrap(err, "failed to remove the file versions")
+	}
+
+	if appErr := p.API.KVDelete(getFileVersionsKey(fileID)); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete the file versions from KV store")
+	}
+	return nil
+}
+
+// removeExpiredFileVersions removes the versions of the file saved before the given time
+func (p *Plugin) removeExpiredFileVersions(fileID string, before int64) error {
+	var removedVersions []*FileVersion
+	err := p.kvAtomicUpdate(getFileVersionsKey(fileID), func(data []byte) ([]byte, error) {
+		versions := []*FileVersion{}
+		if data != nil {
+			if err := json.Unmarshal(data, &versions); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal file versions")
+			}
+		}
+
+		removedVersions = nil
+		kept := make([]*FileVersion, 0, len(versions))
+		for _, version := range versions {
+			if version.CreateAt < before {
+				removedVersions = append(removedVersions, version)
+			} else {
+				kept = append(kept, version)
+			}
+		}
+		if len(kept) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(kept)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save the file versions")
+	}
+
+	if len(removedVersions) == 0 {
+		return nil
+	}
+
+	backend, err := p.getFileBackend()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the file backend")
+	}
+	for _, removedVersion := range removedVersions {
+		if err := backend.RemoveFile(getFileVersionPath(fileID, removedVersion.ID)); err != nil {
+			p.API.LogWarn("Failed to remove expired file version.", "FileID", fileID, "VersionID", removedVersion.ID, "Error", err.Error())
+		}
+	}
+	return nil
+}
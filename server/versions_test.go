@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestPruneFileVersions(t *testing.T) {
+	now := model.GetMillis()
+	expired := now - fileVersionRetention.Milliseconds() - time.Hour.Milliseconds()
+
+	tests := []struct {
+		name string
+
+		// deleteFile deletes the file, its post, or makes the API fail
+		deleteFile func(api *testAPI, post *model.Post, fileInfo *model.FileInfo)
+
+		expectedVersions []string
+	}{
+		{
+			name:             "existing file",
+			deleteFile:       func(*testAPI, *model.Post, *model.FileInfo) {},
+			expectedVersions: []string{"recent"},
+		},
+		{
+			name:       "deleted file",
+			deleteFile: func(api *testAPI, _ *model.Post, fileInfo *model.FileInfo) { delete(api.files, fileInfo.Id) },
+		},
+		{
+			name:       "file marked as deleted",
+			deleteFile: func(_ *testAPI, _ *model.Post, fileInfo *model.FileInfo) { fileInfo.DeleteAt = now },
+		},
+		{
+			name:       "deleted post",
+			deleteFile: func(api *testAPI, post *model.Post, _ *model.FileInfo) { delete(api.posts, post.Id) },
+		},
+		{
+			name:       "post marked as deleted",
+			deleteFile: func(_ *testAPI, post *model.Post, _ *model.FileInfo) { post.DeleteAt = now },
+		},
+		{
+			name: "the file can't be checked",
+			deleteFile: func(api *testAPI, _ *model.Post, _ *model.FileInfo) {
+				api.failures["GetFileInfo"] = model.NewAppError("GetFileInfo", "app.internal", nil, "", http.StatusInternalServerError)
+			},
+			expectedVersions: []string{"recent", "old"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestAPI()
+			api.useLocalFileStore(t.TempDir())
+			p := newTestPlugin(api)
+			author := api.addUser("system_user")
+			_, post, fileInfo := api.addFile(author.Id)
+
+			backend, err := p.getFileBackend()
+			if err != nil {
+				t.Fatalf("failed to get the file backend: %v", err)
+			}
+			versions := []*FileVersion{{ID: "recent", CreateAt: now}, {ID: "old", CreateAt: expired}}
+			for _, version := range versions {
+				if _, err := backend.WriteFile(strings.NewReader(version.ID), getFileVersionPath(fileInfo.Id, version.ID)); err != nil {
+					t.Fatalf("failed to write the version: %v", err)
+				}
+			}
+			api.kv[getFileVersionsKey(fileInfo.Id)], _ = json.Marshal(versions)
+
+			test.deleteFile(api, post, fileInfo)
+			if err := p.pruneFileVersions(); err != nil {
+				t.Fatalf("failed to prune the versions: %v", err)
+			}
+
+			kept, err := p.getFileVersions(fileInfo.Id)
+			if err != nil {
+				t.Fatalf("failed to get the versions: %v", err)
+			}
+			var keptIDs []string
+			for _, version := range kept {
+				keptIDs = append(keptIDs, version.ID)
+			}
+			if strings.Join(keptIDs, ",") != strings.Join(test.expectedVersions, ",") {
+				t.Errorf("expected the versions %v to be kept, got %v", test.expectedVersions, keptIDs)
+			}
+			if len(test.expectedVersions) == 0 && len(api.kvKeysWithPrefix(fileVersionsKeyPrefix)) != 0 {
+				t.Errorf("expected the versions to be removed from the KV store")
+			}
+
+			expectedContents := map[string]bool{}
+			for _, id := range test.expectedVersions {
+				expectedContents[id] = true
+			}
+			for _, version := range versions {
+				exists, _ := backend.FileExists(getFileVersionPath(fileInfo.Id, version.ID))
+				if expected := expectedContents[version.ID]; exists != expected {
+					t.Errorf("expected the contents of the version %s to exist: %v, got %v", version.ID, expected, exists)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+import {Dispatch} from 'redux';
+
+import {DispatchFunc} from 'mattermost-redux/types/actions';
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import Constants from '../constants';
+import Client from '../client';
+
+export const showRequestReviewModal = (files: FileInfo[]) => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.SHOW_REQUEST_REVIEW_MODAL,
+        files,
+    });
+};
+
+export const closeRequestReviewModal = () => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.CLOSE_REQUEST_REVIEW_MODAL,
+    });
+};
+
+export function requestReview(fileID: string, reviewers: string[]): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.requestReview(fileID, reviewers);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
@@ -0,0 +1,56 @@
+import {Dispatch} from 'redux';
+
+import {DispatchFunc} from 'mattermost-redux/types/actions';
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import Constants from '../constants';
+import Client from '../client';
+
+export const showCheckoutModal = (files: FileInfo[]) => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.SHOW_CHECKOUT_MODAL,
+        files,
+    });
+};
+
+export const closeCheckoutModal = () => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.CLOSE_CHECKOUT_MODAL,
+    });
+};
+
+export function getCheckout(fileID: string): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.getCheckout(fileID);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
+
+export function checkOut(fileID: string): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.checkOut(fileID);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
+
+export function checkIn(fileID: string, comment: string): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.checkIn(fileID, comment);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
@@ -0,0 +1,15 @@
+import {DispatchFunc} from 'mattermost-redux/types/actions';
+
+import Client from '../client';
+
+export function runDiagnostics(): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.runDiagnostics();
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
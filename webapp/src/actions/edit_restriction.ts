@@ -0,0 +1,44 @@
+import {Dispatch} from 'redux';
+
+import {DispatchFunc} from 'mattermost-redux/types/actions';
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import Constants from '../constants';
+import Client from '../client';
+
+export const showEditRestrictionModal = (files: FileInfo[]) => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.SHOW_EDIT_RESTRICTION_MODAL,
+        files,
+    });
+};
+
+export const closeEditRestrictionModal = () => (dispatch: Dispatch) => {
+    dispatch({
+        type: Constants.ACTION_TYPES.CLOSE_EDIT_RESTRICTION_MODAL,
+    });
+};
+
+export function getEditRestriction(fileID: string): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.getEditRestriction(fileID);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
+
+export function setEditRestriction(fileID: string, mode: string, usernames: string[]): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.setEditRestriction(fileID, mode, usernames);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
@@ -1,4 +1,4 @@
-import {getWopiFilesList, getCollaboraFileURL} from './wopi';
+import {getWopiFilesList, getCollaboraFileURL, refreshAccessToken} from './wopi';
 import {showFilePreview, closeFilePreview} from './preview';
 import {createFileFromTemplate, closeFileCreateModal, showFileCreateModal} from './file';
 
@@ -6,6 +6,7 @@ export default {
     showFilePreview,
     closeFilePreview,
     getCollaboraFileURL,
+    refreshAccessToken,
     getWopiFilesList,
     createFileFromTemplate,
     closeFileCreateModal,
@@ -1,7 +1,9 @@
 import {AnyAction, Dispatch} from 'redux';
-import {ThunkAction} from 'redux-thunk';
+import {ThunkAction, ThunkDispatch} from 'redux-thunk';
 
+import {FileTypes} from 'mattermost-redux/action_types';
 import {ActionResult, DispatchFunc} from 'mattermost-redux/types/actions';
+import {GlobalState} from 'mattermost-redux/types/store';
 
 import Client from '../client';
 import Constants from '../constants';
@@ -18,6 +20,18 @@ export function getCollaboraFileURL(fileID: string): DispatchFunc {
     };
 }
 
+export function refreshAccessToken(fileID: string): DispatchFunc {
+    return async () => {
+        let data = null;
+        try {
+            data = await Client.refreshAccessToken(fileID);
+        } catch (error) {
+            return {data, error};
+        }
+        return {data, error: null};
+    };
+}
+
 export function getWopiFilesList(): ThunkAction<Promise<ActionResult>, any, undefined, AnyAction> {
     return async (dispatch: Dispatch) => {
         let data = null;
@@ -33,3 +47,64 @@ export function getWopiFilesList(): ThunkAction<Promise<ActionResult>, any, unde
         return {data, error: null};
     };
 }
+
+export function getCapabilities(): ThunkAction<Promise<ActionResult>, any, undefined, AnyAction> {
+    return async (dispatch: Dispatch) => {
+        let data = null;
+        try {
+            data = await Client.getCapabilities();
+        } catch (error) {
+            return {data, error};
+        }
+        dispatch({
+            type: Constants.ACTION_TYPES.RECEIVED_CAPABILITIES,
+            data,
+        });
+        return {data, error: null};
+    };
+}
+
+// handleDiscoveryUpdated reloads the supported files when Collabora Online is reachable again, or was upgraded
+export const handleDiscoveryUpdated = (dispatch: ThunkDispatch<any, undefined, AnyAction>) => () => {
+    dispatch(getWopiFilesList());
+    dispatch(getCapabilities());
+};
+
+type FileUpdate = {
+    file_id: string;
+    post_id: string;
+    name: string;
+    size: number;
+    mime_type: string;
+    update_at: number;
+}
+
+// handleFileUpdated applies the changes of a file saved in Collabora Online to the files of its post,
+// so the channel shows its new size without reloading the page
+export const handleFileUpdated = (dispatch: ThunkDispatch<GlobalState, undefined, AnyAction>) => (msg: {data: FileUpdate}) => {
+    dispatch(applyFileUpdate(msg.data));
+};
+
+const applyFileUpdate = (update: FileUpdate) => (dispatch: Dispatch, getState: () => GlobalState) => {
+    const {files, fileIdsByPostId} = getState().entities.files;
+    if (!files[update.file_id]) {
+        return;
+    }
+
+    // the files of the post are received all at once, as they replace the list of the files of the post
+    const postFiles = (fileIdsByPostId[update.post_id] || [update.file_id]).
+        filter((fileID) => files[fileID]).
+        map((fileID) => (fileID === update.file_id ? {
+            ...files[fileID],
+            name: update.name,
+            size: update.size,
+            mime_type: update.mime_type || files[fileID].mime_type,
+            update_at: update.update_at,
+        } : files[fileID]));
+
+    dispatch({
+        type: FileTypes.RECEIVED_FILES_FOR_POST,
+        postId: update.post_id,
+        data: postFiles,
+    });
+};
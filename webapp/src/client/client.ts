@@ -25,10 +25,15 @@ export default class Client {
     };
 
     getWopiFilesList = () => {
-        // fetch wopiFiles, a JSON with file extensions, actions (view/edit) and the Collabora Online URL where the action is done
+        // fetch wopiFiles, a JSON with the apps handling each file extension and MIME type, and the Collabora Online URLs of their actions (edit, view, view_comment)
         return this.doGet(this.baseURL + '/wopiFileList');
     }
 
+    getCapabilities = () => {
+        // fetch the features supported by the Collabora Online server, with its product name and version
+        return this.doGet(this.baseURL + '/capabilities');
+    }
+
     getCollaboraOnlineURL = (fileID: string) => {
         // fetch the Collabora Online URL & token where the file will be edited
         const params = {
@@ -38,6 +43,43 @@ export default class Client {
         return this.doGet(url);
     }
 
+    refreshAccessToken = (fileID: string) => {
+        // fetch a new token for an open Collabora Online session
+        const params = {
+            file_id: fileID,
+        };
+        return this.doPost(`${this.baseURL}/accessToken${this.buildQueryString(params)}`);
+    }
+
+    getEditRestriction = (fileID: string) => {
+        return this.doGet(`${this.baseURL}/files/${fileID}/editRestriction`);
+    }
+
+    setEditRestriction = (fileID: string, mode: string, usernames: string[]) => {
+        return this.doPut(`${this.baseURL}/files/${fileID}/editRestriction`, {mode, usernames} as unknown as BodyInit);
+    }
+
+    requestReview = (fileID: string, reviewers: string[]) => {
+        return this.doPost(`${this.baseURL}/files/${fileID}/approval`, {reviewers} as unknown as BodyInit);
+    }
+
+    getCheckout = (fileID: string) => {
+        return this.doGet(`${this.baseURL}/files/${fileID}/checkout`);
+    }
+
+    checkOut = (fileID: string) => {
+        return this.doPost(`${this.baseURL}/files/${fileID}/checkout`);
+    }
+
+    checkIn = (fileID: string, comment: string) => {
+        return this.doPost(`${this.baseURL}/files/${fileID}/checkin`, {comment} as unknown as BodyInit);
+    }
+
+    runDiagnostics = () => {
+        // check the connection with Collabora Online, as saved in the system console
+        return this.doPost(`${this.baseURL}/admin/diagnostics`);
+    }
+
     doGet = async (url: string, headers: Record<string, string> = {}) => {
         const options = {
             method: 'get',
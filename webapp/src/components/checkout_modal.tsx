@@ -0,0 +1,166 @@
+import React, {FC, useCallback, useEffect, useState} from 'react';
+import {useDispatch, useSelector} from 'react-redux';
+import {Modal, FormGroup, FormControl, ControlLabel} from 'react-bootstrap';
+import clsx from 'clsx';
+
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import {checkIn, checkOut, closeCheckoutModal, getCheckout} from 'actions/checkout';
+import {checkoutModal} from 'selectors';
+
+type CheckoutModalSelector = {
+    visible: boolean;
+    files: FileInfo[];
+}
+
+type Checkout = {
+    checked_out: boolean;
+    username: string;
+    expires_at: number;
+    can_check_out: boolean;
+    can_check_in: boolean;
+}
+
+export const CheckoutModal: FC = () => {
+    const {visible, files}: CheckoutModalSelector = useSelector(checkoutModal);
+    const dispatch = useDispatch();
+
+    const [fileID, setFileID] = useState('');
+    const [checkout, setCheckout] = useState<Checkout | null>(null);
+    const [comment, setComment] = useState('');
+    const [error, setError] = useState('');
+    const [saving, setSaving] = useState(false);
+
+    // select the first file when the modal opens
+    useEffect(() => {
+        setFileID(files?.[0]?.id || '');
+    }, [files]);
+
+    // load the check-out state of the selected file
+    useEffect(() => {
+        if (!fileID) {
+            return;
+        }
+
+        setError('');
+        setCheckout(null);
+        (async () => {
+            const dispatchResult = await dispatch(getCheckout(fileID) as any);
+            if (dispatchResult.error) {
+                setError(dispatchResult.error.message);
+                return;
+            }
+            setCheckout(dispatchResult.data as Checkout);
+        })();
+    }, [dispatch, fileID]);
+
+    const updateFileID = (e: React.ChangeEvent<FormControl>) => {
+        setFileID((e as unknown as React.ChangeEvent<HTMLSelectElement>).target.value);
+    };
+
+    const updateComment = (e: React.ChangeEvent<FormControl>) => {
+        setComment((e as unknown as React.ChangeEvent<HTMLTextAreaElement>).target.value);
+    };
+
+    const handleClose = useCallback((e?: React.MouseEvent<HTMLButtonElement>) => {
+        e?.preventDefault?.();
+        dispatch(closeCheckoutModal());
+        setComment('');
+        setError('');
+    }, [dispatch]);
+
+    const handleConfirm = useCallback(async () => {
+        setSaving(true);
+        const action = checkout?.checked_out ? checkIn(fileID, comment) : checkOut(fileID);
+        const dispatchResult = await dispatch(action as any);
+        setSaving(false);
+        if (dispatchResult.error) {
+            setError(dispatchResult.error.message);
+            return;
+        }
+        handleClose();
+    }, [dispatch, fileID, checkout, comment, handleClose]);
+
+    const allowed = checkout?.checked_out ? checkout.can_check_in : checkout?.can_check_out;
+
+    let description = '';
+    if (checkout?.checked_out) {
+        description = `Checked out by @${checkout.username} until ${new Date(checkout.expires_at).toLocaleString()}. The other users can only view the file.`;
+    } else if (checkout) {
+        description = 'Check out the file to edit it alone. The other users can only view it until you check it in.';
+    }
+
+    return (
+        <Modal
+            show={visible}
+            onHide={handleClose}
+        >
+            <Modal.Header closeButton={true}>
+                <h4 className='modal-title'>
+                    {'Check out / check in'}
+                </h4>
+            </Modal.Header>
+            <Modal.Body>
+                {files?.length > 1 && (
+                    <FormGroup controlId='checkoutFile'>
+                        <FormControl
+                            componentClass='select'
+                            value={fileID}
+                            onChange={updateFileID}
+                        >
+                            {files.map((file) => (
+                                <option
+                                    key={file.id}
+                                    value={file.id}
+                                >
+                                    {file.name}
+                                </option>
+                            ))}
+                        </FormControl>
+                    </FormGroup>
+                )}
+                <div className='help-text'>
+                    {description}
+                </div>
+                {checkout?.checked_out && checkout.can_check_in && (
+                    <FormGroup controlId='checkinComment'>
+                        <ControlLabel>{'Comment'}</ControlLabel>
+                        <FormControl
+                            componentClass='textarea'
+                            autoFocus={true}
+                            value={comment}
+                            onChange={updateComment}
+                            placeholder={'What did you change? (optional)'}
+                        />
+                    </FormGroup>
+                )}
+                {error && (
+                    <div className='has-error'>
+                        <label className='control-label'>{error}</label>
+                    </div>
+                )}
+            </Modal.Body>
+            <Modal.Footer>
+                <button
+                    type='button'
+                    className='btn btn-link cancel'
+                    onClick={handleClose}
+                >
+                    {'Cancel'}
+                </button>
+                <button
+                    type='submit'
+                    className={clsx('btn btn-primary confirm', {
+                        disabled: !allowed || saving,
+                    })}
+                    onClick={handleConfirm}
+                    disabled={!allowed || saving}
+                >
+                    {checkout?.checked_out ? 'Check in' : 'Check out'}
+                </button>
+            </Modal.Footer>
+        </Modal>
+    );
+};
+
+export default CheckoutModal;
@@ -0,0 +1,119 @@
+import React, {FC, ReactNode, useCallback, useState} from 'react';
+import {useDispatch} from 'react-redux';
+import clsx from 'clsx';
+
+import {runDiagnostics} from 'actions/diagnostics';
+
+type Props = {
+    id: string;
+    label: string;
+    helpText: ReactNode;
+    disabled: boolean;
+}
+
+type DiagnosticCheck = {
+    name: string;
+    status: 'ok' | 'warning' | 'error' | 'skipped';
+    message: string;
+    details?: Record<string, string>;
+}
+
+type DiagnosticsReport = {
+    status: string;
+    wopi_address: string;
+    ran_at: number;
+    checks: DiagnosticCheck[];
+}
+
+const CHECK_TITLES: Record<string, string> = {
+    tls: 'Certificate',
+    discovery: 'Discovery',
+    capabilities: 'Capabilities',
+    wopi_url_from_mattermost: 'WOPI URL from Mattermost',
+    clock_skew: 'Clock skew',
+    token_signing: 'Token signing',
+};
+
+const STATUS_ICONS: Record<string, string> = {
+    ok: 'icon-check-circle',
+    warning: 'icon-alert-outline',
+    error: 'icon-alert-circle-outline',
+    skipped: 'icon-minus-circle-outline',
+};
+
+// ConnectionDiagnostics is the "Test connection" setting of the system console, running the diagnostics of the server
+export const ConnectionDiagnostics: FC<Props> = ({id, label, helpText, disabled}: Props) => {
+    const dispatch = useDispatch();
+
+    const [report, setReport] = useState<DiagnosticsReport | null>(null);
+    const [error, setError] = useState('');
+    const [running, setRunning] = useState(false);
+
+    const handleRun = useCallback(async (e: React.MouseEvent<HTMLButtonElement>) => {
+        e.preventDefault();
+        setRunning(true);
+        setError('');
+        const dispatchResult = await dispatch(runDiagnostics() as any);
+        setRunning(false);
+        if (dispatchResult.error) {
+            setReport(null);
+            setError(dispatchResult.error.message);
+            return;
+        }
+        setReport(dispatchResult.data as DiagnosticsReport);
+    }, [dispatch]);
+
+    return (
+        <div
+            id={id}
+            className='form-group'
+        >
+            <label className='control-label col-sm-4'>
+                {label}
+            </label>
+            <div className='col-sm-8'>
+                <button
+                    type='button'
+                    className={clsx('btn btn-default', {disabled: disabled || running})}
+                    onClick={handleRun}
+                    disabled={disabled || running}
+                >
+                    {running ? 'Testing...' : 'Test connection'}
+                </button>
+                {error && (
+                    <div className='has-error'>
+                        <label className='control-label'>{error}</label>
+                    </div>
+                )}
+                {report && (
+                    <ul className='collabora-diagnostics'>
+                        {report.checks.map((check) => (
+                            <li
+                                key={check.name}
+                                className={`collabora-diagnostics-${check.status}`}
+                            >
+                                <i className={`icon ${STATUS_ICONS[check.status]}`}/>
+                                <strong>{CHECK_TITLES[check.name] || check.name}</strong>
+                                {': '}
+                                {check.message}
+                                {Object.entries(check.details || {}).map(([key, value]) => (
+                                    <div
+                                        key={key}
+                                        className='help-text'
+                                    >
+                                        {`${key}: ${value}`}
+                                    </div>
+                                ))}
+                            </li>
+                        ))}
+                    </ul>
+                )}
+                <div className='help-text'>
+                    {helpText}
+                </div>
+            </div>
+        </div>
+    );
+};
+
+export default ConnectionDiagnostics;
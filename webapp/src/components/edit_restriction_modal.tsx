@@ -0,0 +1,175 @@
+import React, {FC, useCallback, useEffect, useState} from 'react';
+import {useDispatch, useSelector} from 'react-redux';
+import {Modal, FormGroup, FormControl, Radio} from 'react-bootstrap';
+import clsx from 'clsx';
+
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import {closeEditRestrictionModal, getEditRestriction, setEditRestriction} from 'actions/edit_restriction';
+import {editRestrictionModal} from 'selectors';
+
+import {EDIT_RESTRICTION_MODES} from '../constants';
+
+type EditRestrictionModalSelector = {
+    visible: boolean;
+    files: FileInfo[];
+}
+
+type EditRestriction = {
+    mode: EDIT_RESTRICTION_MODES;
+    usernames: string[];
+    can_manage: boolean;
+}
+
+export const EditRestrictionModal: FC = () => {
+    const {visible, files}: EditRestrictionModalSelector = useSelector(editRestrictionModal);
+    const dispatch = useDispatch();
+
+    const [fileID, setFileID] = useState('');
+    const [mode, setMode] = useState(EDIT_RESTRICTION_MODES.NONE);
+    const [usernames, setUsernames] = useState('');
+    const [error, setError] = useState('');
+    const [saving, setSaving] = useState(false);
+
+    // select the first file when the modal opens
+    useEffect(() => {
+        setFileID(files?.[0]?.id || '');
+    }, [files]);
+
+    // load the current restriction of the selected file
+    useEffect(() => {
+        if (!fileID) {
+            return;
+        }
+
+        setError('');
+        (async () => {
+            const dispatchResult = await dispatch(getEditRestriction(fileID) as any);
+            if (dispatchResult.error) {
+                setError(dispatchResult.error.message);
+                return;
+            }
+
+            const restriction = dispatchResult.data as EditRestriction;
+            setMode(restriction.mode);
+            setUsernames(restriction.usernames.map((username) => '@' + username).join(', '));
+        })();
+    }, [dispatch, fileID]);
+
+    const updateFileID = (e: React.ChangeEvent<FormControl>) => {
+        setFileID((e as unknown as React.ChangeEvent<HTMLSelectElement>).target.value);
+    };
+
+    const updateUsernames = (e: React.ChangeEvent<FormControl>) => {
+        setUsernames((e as unknown as React.ChangeEvent<HTMLInputElement>).target.value);
+    };
+
+    const handleClose = useCallback((e?: React.MouseEvent<HTMLButtonElement>) => {
+        e?.preventDefault?.();
+        dispatch(closeEditRestrictionModal());
+        setError('');
+    }, [dispatch]);
+
+    const handleConfirm = useCallback(async () => {
+        const names = usernames.split(/[\s,]+/).filter(Boolean);
+        setSaving(true);
+        const dispatchResult = await dispatch(setEditRestriction(fileID, mode, mode === EDIT_RESTRICTION_MODES.USERS ? names : []) as any);
+        setSaving(false);
+        if (dispatchResult.error) {
+            setError(dispatchResult.error.message);
+            return;
+        }
+        handleClose();
+    }, [dispatch, fileID, mode, usernames, handleClose]);
+
+    const invalid = !fileID || (mode === EDIT_RESTRICTION_MODES.USERS && !usernames.trim());
+
+    return (
+        <Modal
+            show={visible}
+            onHide={handleClose}
+        >
+            <Modal.Header closeButton={true}>
+                <h4 className='modal-title'>
+                    {'Restrict editing'}
+                </h4>
+            </Modal.Header>
+            <Modal.Body>
+                {files?.length > 1 && (
+                    <FormGroup controlId='editRestrictionFile'>
+                        <FormControl
+                            componentClass='select'
+                            value={fileID}
+                            onChange={updateFileID}
+                        >
+                            {files.map((file) => (
+                                <option
+                                    key={file.id}
+                                    value={file.id}
+                                >
+                                    {file.name}
+                                </option>
+                            ))}
+                        </FormControl>
+                    </FormGroup>
+                )}
+                <FormGroup>
+                    <Radio
+                        name='editRestrictionMode'
+                        checked={mode === EDIT_RESTRICTION_MODES.NONE}
+                        onChange={() => setMode(EDIT_RESTRICTION_MODES.NONE)}
+                    >
+                        {'Everyone allowed to edit the files of this channel'}
+                    </Radio>
+                    <Radio
+                        name='editRestrictionMode'
+                        checked={mode === EDIT_RESTRICTION_MODES.READ_ONLY}
+                        onChange={() => setMode(EDIT_RESTRICTION_MODES.READ_ONLY)}
+                    >
+                        {'Nobody, the file is read-only'}
+                    </Radio>
+                    <Radio
+                        name='editRestrictionMode'
+                        checked={mode === EDIT_RESTRICTION_MODES.USERS}
+                        onChange={() => setMode(EDIT_RESTRICTION_MODES.USERS)}
+                    >
+                        {'Only these users:'}
+                    </Radio>
+                    <FormControl
+                        type='text'
+                        value={usernames}
+                        onChange={updateUsernames}
+                        disabled={mode !== EDIT_RESTRICTION_MODES.USERS}
+                        placeholder={'@username, @username'}
+                    />
+                </FormGroup>
+                {error && (
+                    <div className='has-error'>
+                        <label className='control-label'>{error}</label>
+                    </div>
+                )}
+            </Modal.Body>
+            <Modal.Footer>
+                <button
+                    type='button'
+                    className='btn btn-link cancel'
+                    onClick={handleClose}
+                >
+                    {'Cancel'}
+                </button>
+                <button
+                    type='submit'
+                    className={clsx('btn btn-primary confirm', {
+                        disabled: invalid || saving,
+                    })}
+                    onClick={handleConfirm}
+                    disabled={invalid || saving}
+                >
+                    {'Save'}
+                </button>
+            </Modal.Footer>
+        </Modal>
+    );
+};
+
+export default EditRestrictionModal;
@@ -4,7 +4,7 @@ import {Button} from 'react-bootstrap';
 
 import {FileInfo} from 'mattermost-redux/types/files';
 
-import WopiFilePreview from 'components/wopi_file_preview';
+import WopiFilePreview, {FilePermissions} from 'components/wopi_file_preview';
 
 type Props = {
     fileInfo: FileInfo;
@@ -13,15 +13,20 @@ type Props = {
 const FilePreviewComponent: FC<Props> = ({fileInfo}: Props) => {
     const [loading, setLoading] = useState(true);
     const [editable, setEditable] = useState(false);
+    const [canWrite, setCanWrite] = useState(false);
     const enableEditing = useCallback(() => setEditable(true), []);
+    const setPermissions = useCallback((permissions: FilePermissions) => {
+        setCanWrite(permissions.can_edit || permissions.can_comment);
+    }, []);
     return (
         <>
             <WopiFilePreview
                 fileInfo={fileInfo}
                 editable={editable}
                 setLoading={setLoading}
+                setPermissions={setPermissions}
             />
-            {!loading && !editable && (
+            {!loading && !editable && canWrite && (
                 <Button onClick={enableEditing}>
                     <span className='wopi-switch-to-edit-mode'>
                         <i className='fa fa-pencil-square-o'/>
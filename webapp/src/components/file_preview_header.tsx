@@ -9,6 +9,7 @@ import {getPost} from 'mattermost-redux/selectors/entities/posts';
 import {getChannel} from 'mattermost-redux/selectors/entities/channels';
 
 import Client from 'client';
+import {capabilities as getCapabilities} from 'selectors';
 
 import {CHANNEL_TYPES} from '../constants';
 
@@ -18,12 +19,14 @@ type Props = {
     fileInfo: FileInfo;
     onClose: () => void;
     editable: boolean;
+    canWrite: boolean;
     toggleEditing: () => void;
 }
 
-export const FilePreviewHeader: FC<Props> = ({fileInfo, onClose, editable, toggleEditing}: Props) => {
+export const FilePreviewHeader: FC<Props> = ({fileInfo, onClose, editable, canWrite, toggleEditing}: Props) => {
     const post = useSelector((state: GlobalState) => getPost(state, fileInfo.post_id || ''));
     const channel = useSelector((state: GlobalState) => getChannel(state, post?.channel_id));
+    const capabilities = useSelector(getCapabilities);
     const channelName: React.ReactNode = useMemo(() => {
         if (!channel) {
             return '';
@@ -66,6 +69,7 @@ export const FilePreviewHeader: FC<Props> = ({fileInfo, onClose, editable, toggl
                         padding: '12px 16px',
                         minWidth: 0,
                     }}
+                    title={capabilities?.productName ? `${capabilities.productName} ${capabilities.productVersion}` : undefined}
                 >
                     <div
                         style={{
@@ -121,24 +125,26 @@ export const FilePreviewHeader: FC<Props> = ({fileInfo, onClose, editable, toggl
                     >
                         <i className='fa fa-cloud-download'/>
                     </Button>
-                    <Button
-                        bsSize='large'
-                        bsStyle='large'
-                        onClick={toggleEditing}
-                        className='collabora-header-action-button'
-                        title={`${editable ? 'Lock' : 'Unlock'} Editing`}
-                        aria-label={`${editable ? 'Lock' : 'Unlock'} Editing`}
-                    >
-                        <i
-                            className={clsx(
-                                'fa',
-                                {
-                                    'fa-lock': !editable,
-                                    'fa-unlock': editable,
-                                },
-                            )}
-                        />
-                    </Button>
+                    {canWrite && (
+                        <Button
+                            bsSize='large'
+                            bsStyle='large'
+                            onClick={toggleEditing}
+                            className='collabora-header-action-button'
+                            title={`${editable ? 'Lock' : 'Unlock'} Editing`}
+                            aria-label={`${editable ? 'Lock' : 'Unlock'} Editing`}
+                        >
+                            <i
+                                className={clsx(
+                                    'fa',
+                                    {
+                                        'fa-lock': !editable,
+                                        'fa-unlock': editable,
+                                    },
+                                )}
+                            />
+                        </Button>
+                    )}
 
                     <div className='collabora-header-actions-separator'/>
                     <CloseIcon
@@ -8,7 +8,7 @@ import {closeFilePreview} from 'actions/preview';
 import {filePreviewModal} from 'selectors';
 
 import FullScreenModal from 'components/full_screen_modal';
-import WopiFilePreview from 'components/wopi_file_preview';
+import WopiFilePreview, {FilePermissions} from 'components/wopi_file_preview';
 import FilePreviewHeader from 'components/file_preview_header';
 
 type FilePreviewModalSelector = {
@@ -20,6 +20,10 @@ const FilePreviewModal: FC = () => {
     const dispatch = useDispatch();
     const {visible, fileInfo}: FilePreviewModalSelector = useSelector(filePreviewModal);
     const [editable, setEditable] = useState(false);
+    const [canWrite, setCanWrite] = useState(false);
+    const setPermissions = useCallback((permissions: FilePermissions) => {
+        setCanWrite(permissions.can_edit || permissions.can_comment);
+    }, []);
     const toggleEditing = useCallback(() => {
         setEditable((prevState) => !prevState);
     }, [setEditable]);
@@ -41,11 +45,13 @@ const FilePreviewModal: FC = () => {
                 fileInfo={fileInfo}
                 onClose={handleClose}
                 editable={editable}
+                canWrite={canWrite}
                 toggleEditing={toggleEditing}
             />
             <WopiFilePreview
                 fileInfo={fileInfo}
                 editable={editable}
+                setPermissions={setPermissions}
             />
         </FullScreenModal>
     );
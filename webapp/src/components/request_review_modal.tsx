@@ -0,0 +1,129 @@
+import React, {FC, useCallback, useEffect, useState} from 'react';
+import {useDispatch, useSelector} from 'react-redux';
+import {Modal, FormGroup, FormControl, ControlLabel} from 'react-bootstrap';
+import clsx from 'clsx';
+
+import {FileInfo} from 'mattermost-redux/types/files';
+
+import {closeRequestReviewModal, requestReview} from 'actions/approval';
+import {requestReviewModal} from 'selectors';
+
+type RequestReviewModalSelector = {
+    visible: boolean;
+    files: FileInfo[];
+}
+
+export const RequestReviewModal: FC = () => {
+    const {visible, files}: RequestReviewModalSelector = useSelector(requestReviewModal);
+    const dispatch = useDispatch();
+
+    const [fileID, setFileID] = useState('');
+    const [reviewers, setReviewers] = useState('');
+    const [error, setError] = useState('');
+    const [saving, setSaving] = useState(false);
+
+    // select the first file when the modal opens
+    useEffect(() => {
+        setFileID(files?.[0]?.id || '');
+    }, [files]);
+
+    const updateFileID = (e: React.ChangeEvent<FormControl>) => {
+        setFileID((e as unknown as React.ChangeEvent<HTMLSelectElement>).target.value);
+    };
+
+    const updateReviewers = (e: React.ChangeEvent<FormControl>) => {
+        setReviewers((e as unknown as React.ChangeEvent<HTMLInputElement>).target.value);
+    };
+
+    const handleClose = useCallback((e?: React.MouseEvent<HTMLButtonElement>) => {
+        e?.preventDefault?.();
+        dispatch(closeRequestReviewModal());
+        setReviewers('');
+        setError('');
+    }, [dispatch]);
+
+    const handleConfirm = useCallback(async () => {
+        setSaving(true);
+        const dispatchResult = await dispatch(requestReview(fileID, reviewers.split(/[\s,]+/).filter(Boolean)) as any);
+        setSaving(false);
+        if (dispatchResult.error) {
+            setError(dispatchResult.error.message);
+            return;
+        }
+        handleClose();
+    }, [dispatch, fileID, reviewers, handleClose]);
+
+    const invalid = !fileID || !reviewers.trim();
+
+    return (
+        <Modal
+            show={visible}
+            onHide={handleClose}
+        >
+            <Modal.Header closeButton={true}>
+                <h4 className='modal-title'>
+                    {'Request review'}
+                </h4>
+            </Modal.Header>
+            <Modal.Body>
+                {files?.length > 1 && (
+                    <FormGroup controlId='requestReviewFile'>
+                        <FormControl
+                            componentClass='select'
+                            value={fileID}
+                            onChange={updateFileID}
+                        >
+                            {files.map((file) => (
+                                <option
+                                    key={file.id}
+                                    value={file.id}
+                                >
+                                    {file.name}
+                                </option>
+                            ))}
+                        </FormControl>
+                    </FormGroup>
+                )}
+                <FormGroup controlId='requestReviewReviewers'>
+                    <ControlLabel>{'Reviewers'}</ControlLabel>
+                    <FormControl
+                        type='text'
+                        autoFocus={true}
+                        value={reviewers}
+                        onChange={updateReviewers}
+                        placeholder={'@username, @username'}
+                    />
+                </FormGroup>
+                <div className='help-text'>
+                    {'The file becomes read-only once all the reviewers approved it.'}
+                </div>
+                {error && (
+                    <div className='has-error'>
+                        <label className='control-label'>{error}</label>
+                    </div>
+                )}
+            </Modal.Body>
+            <Modal.Footer>
+                <button
+                    type='button'
+                    className='btn btn-link cancel'
+                    onClick={handleClose}
+                >
+                    {'Cancel'}
+                </button>
+                <button
+                    type='submit'
+                    className={clsx('btn btn-primary confirm', {
+                        disabled: invalid || saving,
+                    })}
+                    onClick={handleConfirm}
+                    disabled={invalid || saving}
+                >
+                    {'Request review'}
+                </button>
+            </Modal.Footer>
+        </Modal>
+    );
+};
+
+export default RequestReviewModal;
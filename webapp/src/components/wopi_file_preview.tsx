@@ -1,21 +1,43 @@
-import React, {FC, useCallback, useEffect, useState} from 'react';
+import React, {FC, useCallback, useEffect, useRef, useState} from 'react';
 
-import {useDispatch} from 'react-redux';
+import {useDispatch, useSelector} from 'react-redux';
 
 import {FileInfo} from 'mattermost-redux/types/files';
 
-import {getCollaboraFileURL} from 'actions/wopi';
+import {getCollaboraFileURL, refreshAccessToken} from 'actions/wopi';
+import {wopiAvailability} from 'selectors';
+
+// the token is renewed this long before it expires
+const TOKEN_REFRESH_MARGIN = 5 * 60 * 1000;
+
+type AccessToken = {
+    access_token: string;
+    access_token_ttl: number;
+}
+
+export type FilePermissions = {
+    can_view: boolean;
+    can_comment: boolean;
+    can_edit: boolean;
+}
 
 type Props = {
     editable: boolean;
     fileInfo: FileInfo;
     setLoading?: (_: boolean) => void;
+    setPermissions?: (_: FilePermissions) => void;
 }
 
 export const WopiFilePreview: FC<Props> = (props: Props) => {
     const dispatch = useDispatch();
+    const availability = useSelector(wopiAvailability);
     const [error, setError] = useState(false);
+    const [errorMessage, setErrorMessage] = useState('');
+    const [notice, setNotice] = useState('');
     const [loading, setLoadingState] = useState(false);
+    const [collaboraOrigin, setCollaboraOrigin] = useState('');
+    const [tokenTTL, setTokenTTL] = useState(0);
+    const refreshTimeout = useRef<number>();
 
     const setLoading = useCallback((currentlyLoading) => {
         setLoadingState(currentlyLoading);
@@ -32,26 +54,80 @@ export const WopiFilePreview: FC<Props> = (props: Props) => {
         if (dispatchResult.error) {
             setLoading(false);
             setError(true);
+
+            //the server explains why the user isn't allowed to open the file, or why the file can't be opened right now
+            const statusCode = dispatchResult.error.status_code;
+            setErrorMessage(statusCode === 403 || statusCode === 503 ? dispatchResult.error.message.trim() : '');
             return;
         }
 
         setLoading(false);
         setError(false);
 
-        const fileData = dispatchResult.data as {url: string, access_token: string};
+        const fileData = dispatchResult.data as AccessToken & FilePermissions & {url: string, scope: string, action: string, notice?: string};
+        props.setPermissions?.(fileData);
+        setNotice(fileData.notice || '');
+
+        //the server decides if the user can edit the file, view-only tokens are never used for editing,
+        //and the file types Collabora Online can only view are never opened for editing
+        const editable = props.editable && (fileData.can_edit || fileData.can_comment) && fileData.action !== 'view';
 
         //as the request to Collabora Online should be of POST type, a form is used to submit it.
-        (document.getElementById('collabora-submit-form') as HTMLFormElement).action = fileData.url + (props.editable ? '/edit' : '');
+        (document.getElementById('collabora-submit-form') as HTMLFormElement).action = fileData.url + (editable ? '/edit' : '');
         (document.getElementById('collabora-form-access-token') as HTMLInputElement).value = fileData.access_token;
+        (document.getElementById('collabora-form-access-token-ttl') as HTMLInputElement).value = String(fileData.access_token_ttl);
         (document.getElementById('collabora-submit-form') as HTMLFormElement).submit();
-    }, [dispatch, props.editable]);
 
+        setCollaboraOrigin(new URL(fileData.url).origin);
+        setTokenTTL(fileData.access_token_ttl);
+    }, [dispatch, props.editable, props.setPermissions]);
+
+    // renew the token before it expires and pass it to the open Collabora Online session
+    useEffect(() => {
+        const fileID = props.fileInfo?.id;
+        if (!fileID || !tokenTTL) {
+            return undefined;
+        }
+
+        refreshTimeout.current = window.setTimeout(async () => {
+            const dispatchResult = await dispatch(refreshAccessToken(fileID) as any);
+            if (dispatchResult.error) {
+                return;
+            }
+
+            const token = dispatchResult.data as AccessToken;
+            const iframe = document.getElementById('collabora-iframe') as HTMLIFrameElement | null;
+            iframe?.contentWindow?.postMessage(JSON.stringify({
+                MessageId: 'Reset_Access_Token',
+                SendTime: Date.now(),
+                Values: {
+                    token: token.access_token,
+                    token_ttl: token.access_token_ttl,
+                },
+            }), collaboraOrigin);
+            setTokenTTL(token.access_token_ttl);
+        }, Math.max(tokenTTL - Date.now() - TOKEN_REFRESH_MARGIN, 0));
+
+        return () => window.clearTimeout(refreshTimeout.current);
+    }, [dispatch, props.fileInfo, tokenTTL, collaboraOrigin]);
+
+    // the file is opened again once Collabora Online can be reached
     useEffect(() => {
         const fileID = props.fileInfo?.id;
-        if (fileID) {
+        if (fileID && availability.available) {
             handleWopiFile(fileID);
         }
-    }, [handleWopiFile, props.fileInfo]);
+    }, [handleWopiFile, props.fileInfo, availability.available]);
+
+    if (!availability.available) {
+        return (
+            <div className='alert wopi-error'>
+                <i className='fa fa-warning wopi-error-icon'/>
+                <div>{'We\'re sorry, a file preview is not available.'}</div>
+                <div>{availability.message || 'Please download to view the file.'}</div>
+            </div>
+        );
+    }
 
     if (loading) {
         return (
@@ -72,32 +148,46 @@ export const WopiFilePreview: FC<Props> = (props: Props) => {
             <div className='alert wopi-error'>
                 <i className='fa fa-warning wopi-error-icon'/>
                 <div>{'We\'re sorry, a file preview is not available.'}</div>
-                <div>{'Please download to view the file.'}</div>
+                <div>{errorMessage || 'Please download to view the file.'}</div>
             </div>
         );
     }
 
     return (
-        <div className='wopi-iframe-container'>
-            <form
-                action=''
-                method='POST'
-                target='collabora-iframe'
-                id='collabora-submit-form'
-            >
-                <input
-                    id='collabora-form-access-token'
-                    name='access_token'
-                    value=''
-                    type='hidden'
+        <>
+            {notice && (
+                <div className='wopi-notice'>
+                    <i className='fa fa-info-circle'/>
+                    {` ${notice}`}
+                </div>
+            )}
+            <div className='wopi-iframe-container'>
+                <form
+                    action=''
+                    method='POST'
+                    target='collabora-iframe'
+                    id='collabora-submit-form'
+                >
+                    <input
+                        id='collabora-form-access-token'
+                        name='access_token'
+                        value=''
+                        type='hidden'
+                    />
+                    <input
+                        id='collabora-form-access-token-ttl'
+                        name='access_token_ttl'
+                        value=''
+                        type='hidden'
+                    />
+                </form>
+                <iframe
+                    id='collabora-iframe'
+                    name='collabora-iframe'
+                    className='wopi-iframe'
                 />
-            </form>
-            <iframe
-                id='collabora-iframe'
-                name='collabora-iframe'
-                className='wopi-iframe'
-            />
-        </div>
+            </div>
+        </>
     );
 };
 
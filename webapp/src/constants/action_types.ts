@@ -1,9 +1,19 @@
 import {id as pluginID} from '../manifest';
 
 export const RECEIVED_WOPI_FILES_LIST = pluginID + '_received_wopi_files_list';
+export const RECEIVED_CAPABILITIES = pluginID + '_received_capabilities';
 
 export const SHOW_FILE_PREVIEW = pluginID + '_show_file_preview';
 export const CLOSE_FILE_PREVIEW = pluginID + '_close_file_preview';
 
 export const SHOW_FILE_CREATE_MODAL = pluginID + '_show_file_create_modal';
 export const CLOSE_FILE_CREATE_MODAL = pluginID + '_close_file_create_modal';
+
+export const SHOW_EDIT_RESTRICTION_MODAL = pluginID + '_show_edit_restriction_modal';
+export const CLOSE_EDIT_RESTRICTION_MODAL = pluginID + '_close_edit_restriction_modal';
+
+export const SHOW_REQUEST_REVIEW_MODAL = pluginID + '_show_request_review_modal';
+export const CLOSE_REQUEST_REVIEW_MODAL = pluginID + '_close_request_review_modal';
+
+export const SHOW_CHECKOUT_MODAL = pluginID + '_show_checkout_modal';
+export const CLOSE_CHECKOUT_MODAL = pluginID + '_close_checkout_modal';
@@ -14,6 +14,12 @@ export const FILE_TEMPLATES: Dictionary<string[]> = {
     [TEMPLATE_TYPES.SPREADSHEET]: ['xlsx', 'ods'],
 };
 
+export enum EDIT_RESTRICTION_MODES {
+    NONE = '',
+    READ_ONLY = 'read_only',
+    USERS = 'users',
+}
+
 export const CHANNEL_TYPES = {
     CHANNEL_OPEN: 'O',
     CHANNEL_PRIVATE: 'P',
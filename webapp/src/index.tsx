@@ -7,16 +7,27 @@ import {PluginRegistry} from 'mattermost-webapp/plugins/registry';
 
 import {GlobalState} from 'mattermost-webapp/types/store';
 import {FileInfo} from 'mattermost-redux/types/files';
+import {getPost} from 'mattermost-redux/selectors/entities/posts';
+import {getChannel} from 'mattermost-redux/selectors/entities/channels';
+import {getCurrentUserId} from 'mattermost-redux/selectors/entities/users';
+import {haveIChannelPermission} from 'mattermost-redux/selectors/entities/roles';
 
 import {showFileCreateModal} from 'actions/file';
 import {showFilePreview} from 'actions/preview';
-import {getWopiFilesList} from 'actions/wopi';
+import {showEditRestrictionModal} from 'actions/edit_restriction';
+import {showRequestReviewModal} from 'actions/approval';
+import {showCheckoutModal} from 'actions/checkout';
+import {getCapabilities, getWopiFilesList, handleDiscoveryUpdated, handleFileUpdated} from 'actions/wopi';
 import {wopiFilesList} from 'selectors';
 import Reducer from 'reducers';
 
 import FilePreviewModal from 'components/file_preview_modal';
 import FilePreviewComponent from 'components/file_preview_component';
 import FileCreateModal from 'components/file_create_modal';
+import EditRestrictionModal from 'components/edit_restriction_modal';
+import RequestReviewModal from 'components/request_review_modal';
+import CheckoutModal from 'components/checkout_modal';
+import ConnectionDiagnostics from 'components/connection_diagnostics';
 
 import {TEMPLATE_TYPES} from './constants';
 
@@ -28,15 +39,51 @@ export default class Plugin {
     shouldShowPreview = (store: Store<GlobalState>, fileInfo: FileInfo) => {
         const state = store.getState();
         const wopiFiles = wopiFilesList(state);
-        return Boolean(wopiFiles?.[fileInfo.extension]);
+
+        // the files are handled by their extension, or else by their MIME type
+        return Boolean(wopiFiles?.[fileInfo.extension?.toLowerCase()] || wopiFiles?.[fileInfo.mime_type]);
+    }
+
+    // getPostFiles returns the files of the post that can be opened with Collabora Online
+    getPostFiles = (store: Store<GlobalState>, postID: string): FileInfo[] => {
+        const post = getPost(store.getState(), postID);
+        return (post?.metadata?.files || []).filter((fileInfo) => this.shouldShowPreview(store, fileInfo));
+    }
+
+    // canRestrictEditing checks if the user can restrict the editing of the files of the post:
+    // the user who posted them or a channel admin, as enforced by the server
+    canRestrictEditing = (store: Store<GlobalState>, postID: string) => {
+        const state = store.getState();
+        const post = getPost(state, postID);
+        if (!post || this.getPostFiles(store, postID).length === 0) {
+            return false;
+        }
+
+        if (post.user_id === getCurrentUserId(state)) {
+            return true;
+        }
+
+        const channel = getChannel(state, post.channel_id);
+        return haveIChannelPermission(state, {
+            channel: post.channel_id,
+            team: channel?.team_id,
+            permission: 'manage_channel_roles',
+        });
     }
 
     public initialize(registry: PluginRegistry, store: Store<GlobalState>): void {
         registry.registerReducer(Reducer);
         registry.registerRootComponent(FilePreviewModal);
         registry.registerRootComponent(FileCreateModal);
+        registry.registerRootComponent(EditRestrictionModal);
+        registry.registerRootComponent(RequestReviewModal);
+        registry.registerRootComponent(CheckoutModal);
+        registry.registerAdminConsoleCustomSetting('ConnectionDiagnostics', ConnectionDiagnostics);
         const dispatch: ThunkDispatch<GlobalState, undefined, AnyAction> = store.dispatch;
         dispatch(getWopiFilesList());
+        dispatch(getCapabilities());
+        registry.registerWebSocketEventHandler(`custom_${pluginId}_file_updated`, handleFileUpdated(dispatch));
+        registry.registerWebSocketEventHandler(`custom_${pluginId}_discovery_updated`, handleDiscoveryUpdated(dispatch));
         registry.registerFilePreviewComponent(
             this.shouldShowPreview.bind(null, store),
             (props: {fileInfo: FileInfo}) => <FilePreviewComponent fileInfo={props.fileInfo}/>,
@@ -49,6 +96,22 @@ export default class Plugin {
             (fileInfo: FileInfo) => dispatch(showFilePreview(fileInfo)),
         );
 
+        registry.registerPostDropdownMenuAction(
+            'Restrict editing',
+            (postID: string) => dispatch(showEditRestrictionModal(this.getPostFiles(store, postID))),
+            (postID: string) => this.canRestrictEditing(store, postID),
+        );
+        registry.registerPostDropdownMenuAction(
+            'Request review',
+            (postID: string) => dispatch(showRequestReviewModal(this.getPostFiles(store, postID))),
+            (postID: string) => this.getPostFiles(store, postID).length > 0,
+        );
+        registry.registerPostDropdownMenuAction(
+            'Check out / check in',
+            (postID: string) => dispatch(showCheckoutModal(this.getPostFiles(store, postID))),
+            (postID: string) => this.getPostFiles(store, postID).length > 0,
+        );
+
         registry.registerFileUploadMethod(
             <span className='fa wopi-file-upload-icon icon-filetype-document'/>,
             () => dispatch(showFileCreateModal(TEMPLATE_TYPES.DOCUMENT)),
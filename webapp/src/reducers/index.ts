@@ -1,11 +1,19 @@
 import {combineReducers} from 'redux';
 
-import {wopiFilesList} from './wopi';
+import {wopiFilesList, wopiAvailability, capabilities} from './wopi';
 import {filePreviewModal} from './file_preview_modal';
 import {createFileModal} from './create_file_modal';
+import {editRestrictionModal} from './edit_restriction_modal';
+import {requestReviewModal} from './request_review_modal';
+import {checkoutModal} from './checkout_modal';
 
 export default combineReducers({
     wopiFilesList,
+    wopiAvailability,
+    capabilities,
     filePreviewModal,
     createFileModal,
+    editRestrictionModal,
+    requestReviewModal,
+    checkoutModal,
 });
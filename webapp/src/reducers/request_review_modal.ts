@@ -0,0 +1,24 @@
+import {AnyAction} from 'redux';
+
+import Constants from '../constants';
+
+const initialState = {
+    visible: false,
+    files: [],
+};
+
+export const requestReviewModal = (state = initialState, action: AnyAction) => {
+    switch (action.type) {
+    case Constants.ACTION_TYPES.SHOW_REQUEST_REVIEW_MODAL:
+        return {
+            visible: true,
+            files: action.files,
+        };
+
+    case Constants.ACTION_TYPES.CLOSE_REQUEST_REVIEW_MODAL:
+        return initialState;
+
+    default:
+        return state;
+    }
+};
@@ -2,9 +2,39 @@ import {AnyAction} from 'redux';
 
 import Constants from '../constants';
 
+// wopiFilesList maps the file extensions and MIME types to the apps handling them.
+// While Collabora Online can't be reached, the last known files are kept so their previews still explain why they can't be opened.
 export const wopiFilesList = (state = {}, action: AnyAction) => {
+    switch (action.type) {
+    case Constants.ACTION_TYPES.RECEIVED_WOPI_FILES_LIST: {
+        const files = action.data.files || {};
+        if (!action.data.available && Object.keys(files).length === 0) {
+            return state;
+        }
+        return files;
+    }
+    default:
+        return state;
+    }
+};
+
+// wopiAvailability tells if Collabora Online can be reached, the files can't be opened otherwise
+export const wopiAvailability = (state = {available: true, message: ''}, action: AnyAction) => {
     switch (action.type) {
     case Constants.ACTION_TYPES.RECEIVED_WOPI_FILES_LIST:
+        return {
+            available: action.data.available,
+            message: action.data.message || '',
+        };
+    default:
+        return state;
+    }
+};
+
+// capabilities are the features supported by the Collabora Online server, null if the server didn't provide them
+export const capabilities = (state = null, action: AnyAction) => {
+    switch (action.type) {
+    case Constants.ACTION_TYPES.RECEIVED_CAPABILITIES:
         return action.data;
     default:
         return state;
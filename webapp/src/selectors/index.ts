@@ -7,6 +7,16 @@ const getPluginState = (state: GlobalState) => state['plugins-' + pluginId] || {
 
 export const wopiFilesList = (state: GlobalState) => getPluginState(state).wopiFilesList;
 
+export const wopiAvailability = (state: GlobalState) => getPluginState(state).wopiAvailability;
+
+export const capabilities = (state: GlobalState) => getPluginState(state).capabilities;
+
 export const filePreviewModal = (state: GlobalState) => getPluginState(state).filePreviewModal;
 
 export const createFileModal = (state: GlobalState) => getPluginState(state).createFileModal;
+
+export const editRestrictionModal = (state: GlobalState) => getPluginState(state).editRestrictionModal;
+
+export const requestReviewModal = (state: GlobalState) => getPluginState(state).requestReviewModal;
+
+export const checkoutModal = (state: GlobalState) => getPluginState(state).checkoutModal;